@@ -1,13 +1,34 @@
 package argo
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 )
 
 // Action defines the action to be done for the specified matching args
+//
+// Some settings are inherited by SubActions in Finalize(), which follows these rules:
+//   - Switches are turned on for all SubActions if they are set on an Action, and SubActions cannot turn them off:
+//     MultilineUsage, CollectTimings, AllowAbbrev, HelpBeforeConsume, StrictHelp, HelpColor, SortSubActions,
+//     InferConsumeFromArgNames, TransactionalOutput, RecoverPanics and RejectUnknownTriggers
+//   - Values and functions are inherited from the parent if they are not set on a SubAction, and the root Action
+//     falls back to the default documented on the field: HelpWidth, TerminalWidth, HelpDo, OnError, ChainSeparator,
+//     NotFoundTemplate, HelpGenContext, HelpDepth, Tracer, HelpFlag, HelpShortFlag, DefaultHandler, OnUnmatched,
+//     section titles, PromptFunc, UsageStats, MaxDepth, PathSeparator and UnknownFlagMode
+//   - Settings documented to take effect only on the root Action, or on the Action which Parse() is called with,
+//     are not inherited
+//   - Other settings apply to the Action they are set on only
 type Action struct {
 	// Argument string that trigger this action
+	// Trigger of the Action which Parse() is called with can be a phrase of words separated by spaces
+	// The phrase is matched against leading args case-insensitively, regardless of how spaces split the args
 	Trigger string
 
 	// Do is the fuction which will be executed if this Action is triggered
@@ -25,19 +46,39 @@ type Action struct {
 	// MaxConsume < 0 implies consuming all remaining args
 	MaxConsume int
 
+	// Consume sets MinConsume and MaxConsume with an expression in Finalize()
+	// "2" consumes exactly 2 args, "2..4" consumes 2 to 4 args, "2.." consumes at least 2 args and "*" consumes all args
+	// It cannot be used together with MinConsume or MaxConsume
+	Consume string
+
+	// ConsumeUntil ends consuming args at this separator when MaxConsume < 0
+	// The separator is dropped, and the args following it are used to trigger SubActions
+	// SubActions can be added to an Action with MaxConsume < 0 only if this is set
+	ConsumeUntil string
+
 	// ShortDescr the one-line description of this Action
 	ShortDescr string
 
 	// LongDescr the complete description of this Action
 	LongDescr string
 
+	// Options are named options accepted by this Action, they are not counted as consumed args
+	// Values of given options are stored into State
+	Options []Option
+
 	// ArgNames optional slice of strings used as references for generating help text
 	ArgNames []string
 
+	// VariadicName names the trailing args consumed when MaxConsume is -1, which follow the args of ArgNames
+	// If this is set, help text shows the trailing args as `<name...>` if they are required by MinConsume,
+	// or `[name...]` if they are optional
+	VariadicName string
+
 	// Hidden is true if this action should be hidden in help text
 	Hidden bool
 
 	// DisableHelp avoids auto injecting help SubAction for generating help text
+	// HelpFlag and HelpShortFlag are not recognized either
 	DisableHelp bool
 
 	// HelpTrigger will be used as Trigger for the auto injected Help SubAction
@@ -48,23 +89,296 @@ type Action struct {
 	// If this is not set, it will be assigned as a default generator in Finalize()
 	HelpGen func(Action) string
 
+	// HelpWidth is the maximum width of lines in the default help text, descriptions are wrapped to fit in it
+	// If this is not set on the root Action, 80 will be used
+	// If this is TerminalHelpWidth, the width is queried by TerminalWidth when help text is generated
+	HelpWidth int
+
+	// TerminalWidth returns the width of the terminal, a value <= 0 means the width is unknown
+	// It is used when HelpWidth is TerminalHelpWidth, and 80 is used if the width is unknown
+	// If this is not set on the root Action, the COLUMNS environment variable will be read
+	TerminalWidth func() int
+
+	// MultilineUsage renders each argument on its own line in the usage section of the default help text
+	MultilineUsage bool
+
+	// HelpDo overrides the behavior of the auto injected help SubAction
+	// act is the Action owning the help SubAction, target is the Action which help is requested for
+	// target is nil if the requested SubAction is not found
+	// If this is not set on the root Action, help text is written to State.OutputStr
+	HelpDo func(state *State, act *Action, target *Action)
+
+	// LeadingAssignments enables parsing leading KEY=VALUE args before the Trigger, like shell does
+	// Parsed assignments are available by State.Assignments() and skipped for Trigger matching
+	// This only takes effect on the Action which Parse() is called with
+	LeadingAssignments bool
+
+	// CollectTimings records time spent on each triggered level during Parse(), see State.Timings()
+	CollectTimings bool
+
+	// Default is triggered when the arg following consumed args matches no SubAction
+	// Default consumes all remaining args, including the unmatched one
+	// Trigger of Default is never matched, it is only used in Path() and help text if it is set
+	// Default is not listed in help text if its Trigger is empty or it is Hidden
+	Default *Action
+
+	// SensitiveArgs marks args which should be redacted as "***" in error messages and traces, in parallel with ArgNames
+	// Sensitive args are still passed to Do() as is
+	SensitiveArgs []bool
+
+	// ArgEnv maps positions of optional args to environment variables used when the args are not supplied
+	// Values from environment variables are appended to consumed args in order, until a variable is unset or empty
+	ArgEnv map[int]string
+
+	// AllowAbbrev enables triggering SubActions by unambiguous prefixes of their Triggers
+	// Exact matches always take precedence
+	AllowAbbrev bool
+
+	// StatementSeparator splits the line given to ParseString() into statements, e.g. ";"
+	// Each statement is parsed in order, and statements after the first one are parsed as args following the Trigger,
+	// such as `app set x 1; set y 2`. Empty string (default) disables splitting
+	// This only takes effect on the Action which ParseString() is called with
+	StatementSeparator string
+
+	// ContinueOnError keeps parsing the remaining statements in ParseString() after a statement fails
+	// The first error is returned after all statements are parsed
+	ContinueOnError bool
+
+	// OnError is called once when Parse() fails, before the error is returned
+	// It can be used to write a friendly error message into State.OutputStr
+	OnError func(*State, error)
+
+	// Prompt is written before reading each line in REPL()
+	Prompt string
+
+	// QuitTrigger is the line which ends REPL(). If the string is not set (default), "quit" will be used
+	QuitTrigger string
+
+	// HelpBeforeConsume shows help text of a consuming Action if its first arg is HelpTrigger
+	// instead of consuming HelpTrigger as an arg
+	HelpBeforeConsume bool
+
+	// Capture makes this SubAction triggered by any arg which matches none of its siblings
+	// The triggering arg is captured and can be retrieved by State.Capture() with ArgNames[0] as name,
+	// the following ArgNames are used for consumed args as usual
+	// If Trigger is empty, it will be set as "<ArgNames[0]>" in AddSubAction(). Each Action can have only one Capture SubAction
+	Capture bool
+
+	// ChainSeparator is inserted into State.OutputStr between outputs of Do() calls of consecutively triggered Actions
+	// Empty string (default) inserts nothing
+	ChainSeparator string
+
+	// StrictHelp reports HelpShadowedError in Finalize() if a SubAction has the same Trigger as HelpTrigger
+	// By default, such SubAction silently overrides the injected help SubAction
+	StrictHelp bool
+
+	// NotFoundTemplate generates the message shown when help is requested for a SubAction which does not exist,
+	// and the message of UnknownTriggerError
+	// parentPath is Path() of the Action owning the help SubAction, and token is the requested SubAction
+	// If this is not set on the root Action, a default message is used
+	NotFoundTemplate func(parentPath, token string) string
+
+	// GlobalUniqueTriggers makes Finalize() report GlobalTriggerCollisionError
+	// if a Trigger is used by more than one Action anywhere in the tree
+	// The injected help SubActions and Capture SubActions are not checked
+	// This only takes effect on the Action which Finalize() is called with
+	GlobalUniqueTriggers bool
+
+	// HelpGenContext generates help text with access to the State of the current Parse() call
+	// When set, it overrides HelpGen for the help text displayed during Parse()
+	// HelpGen is still used by Help(), which has no State
+	HelpGenContext func(Action, *State) string
+
+	// ErrorClearsOutput discards output written during a Parse() call if the call fails
+	// Output written by OnError is kept
+	// This only takes effect on the root Action
+	ErrorClearsOutput bool
+
+	// HelpColor styles headers and SubAction Triggers in the default help text with ANSI escape codes
+	HelpColor bool
+
+	// SortSubActions lists SubActions in alphabetical order of Triggers in the default help text
+	// Injected SubActions are listed last. It does not change the order of SubActions() or matching
+	SortSubActions bool
+
+	// MatchFunc checks if token triggers this Action, it replaces comparing token with Trigger
+	// It is used for the root Action in Parse(), and by the parent Action to choose a SubAction
+	// SubActions are checked in registration order if any of them has MatchFunc
+	MatchFunc func(trigger, token string) bool
+
+	// HelpDepth limits the number of args consumed by the injected help SubAction
+	// Each arg selects a SubAction one level deeper, such as `help build clean`
+	// If this is not set on the root Action, all remaining args are consumed
+	HelpDepth int
+
+	// Tracer receives TraceEvent describing each step of Parse()
+	Tracer func(event TraceEvent)
+
+	// BacktrackForSubAction stops consuming optional args (beyond MinConsume) at the first arg which triggers a SubAction
+	// By default, args are consumed up to MaxConsume even if they match SubAction Triggers
+	BacktrackForSubAction bool
+
+	// HelpFlag shows help text of the Action reached so far when it is found in args, such as `cmd build --help`
+	// It is recognized among the args which the Action would consume, and the arg following them, before `--` or ConsumeUntil
+	// Finalize() fails with HelpFlagConflictError if an option accepted by the Action is the same as HelpFlag or HelpShortFlag
+	// If the string is not set on the root Action, "--help" will be used
+	HelpFlag string
+
+	// HelpShortFlag works the same as HelpFlag
+	// If the string is not set on the root Action, "-h" will be used
+	HelpShortFlag string
+
+	// DefaultHandler is called instead of Do when a triggered Action without Do has no SubActions
+	// The triggered Action is passed as act, so that a single handler can serve many Actions
+	DefaultHandler func(state *State, act *Action, vargs ...interface{}) error
+
+	// OnUnmatched is called when Parse() stops with remaining args which trigger nothing
+	// tokens are the remaining args, and at is the last triggered Action. It does not change the result of Parse()
+	OnUnmatched func(tokens []string, at *Action)
+
+	// Version injects a SubAction with Trigger VersionTrigger into the root Action, which shows Version
+	// The root Action shows Version if it is invoked with "--version" as well
+	// If a SubAction with the same Trigger is already added, the version SubAction is not injected
+	Version string
+
+	// VersionTrigger will be used as Trigger for the auto injected version SubAction
+	// If the string is not set (default), "version" will be used as Trigger
+	VersionTrigger string
+
+	// EnableCommandsList injects a SubAction with Trigger CommandsTrigger into the root Action,
+	// which lists all visible invocable Actions in the tree
+	// If a SubAction with the same Trigger is already added, the commands SubAction is not injected
+	EnableCommandsList bool
+
+	// CommandsTrigger will be used as Trigger for the auto injected commands SubAction
+	// If the string is not set (default), "commands" will be used as Trigger
+	CommandsTrigger string
+
+	// InferConsumeFromArgNames sets MinConsume and MaxConsume from ArgNames in Finalize(),
+	// if both of them are 0 and Consume is not set
+	// Bare names are required, such as "src", and names in brackets are optional, such as "[mode]"
+	// Required names must come before optional names, or Finalize() fails with RequiredAfterOptionalError. The last name can end with "..." to consume all remaining args,
+	// such as "rest..." or "[rest...]", and it is used as VariadicName
+	InferConsumeFromArgNames bool
+
+	// UsageTitle, DescriptionTitle, OptionsTitle, GlobalOptionsTitle and SubActionsTitle are section titles
+	// in the default help text. If they are not set on the root Action, "[Usage]",
+	// "[Description]", "[Options]", "[Global Options]" and "[Sub-actions]" will be used
+	UsageTitle         string
+	DescriptionTitle   string
+	OptionsTitle       string
+	GlobalOptionsTitle string
+	SubActionsTitle    string
+
+	// PromptFunc is called for each missing required arg when fewer than MinConsume args are given,
+	// instead of failing with TooFewArgsError. argName is from ArgNames, or "argN" if it is not named
+	// An error returned by PromptFunc fails the Parse() call
+	PromptFunc func(argName string) (string, error)
+
+	// UsageStats returns how often the Action with Path() `path` is used
+	// Completion candidates and candidates in AmbiguousTriggerError, UnknownTriggerError and SubActionRequiredError
+	// are ordered by it, most used first. argo does not record usage by itself
+	UsageStats func(path string) int
+
+	// Preprocess transforms args before Parse() starts matching, such as expanding aliases
+	// An error returned by Preprocess fails the Parse() call
+	// It is called once per Parse() call, only on the Action which Parse() is called with
+	Preprocess func(args []string) ([]string, error)
+
+	// TransactionalOutput discards output written by this Action and the following triggered Actions,
+	// if any of them fails. Output written by Actions triggered before this Action is kept
+	TransactionalOutput bool
+
+	// MaxDepth limits the number of levels of the Action tree, the root Action is at level 1
+	// The injected help SubActions are not counted
+	// Finalize() and Parse() fail with MaxDepthExceededError if an Action is deeper than this
+	// If this is not set on the root Action, 64 will be used
+	MaxDepth int
+
+	// PathSeparator joins Triggers in Path() and help text, such as ":" for `db:migrate`
+	// If it is not " ", a SubAction can also be triggered with the Trigger of its child in one arg, such as `db:migrate`
+	// If this is not set on the root Action, " " will be used
+	PathSeparator string
+
+	// RecoverPanics recovers panics in Do, and fails the Parse() call with ActionPanicError instead
+	RecoverPanics bool
+
+	// UnknownFlagMode decides how args like `--name` matching no option are handled, see FlagPassthrough
+	// FlagInherit (default) on the root Action means FlagPassthrough
+	UnknownFlagMode UnknownFlagMode
+
+	// RequireSubAction makes Parse() fail with SubActionRequiredError if this Action is triggered,
+	// but none of its SubActions or Default is triggered after it. The injected help SubAction is not a choice
+	RequireSubAction bool
+
+	// Deprecated marks this Action as deprecated with a message, such as `use "new"`
+	// A warning with the message is written to State.OutputStr when this Action is triggered, before Do is called
+	// This Action is marked as deprecated in help text of its parent. Empty string (default) means not deprecated
+	Deprecated string
+
+	// RejectUnknownTriggers makes Parse() fail with UnknownTriggerError if args remain after this Action,
+	// but they trigger none of its SubActions. It only applies to Actions with SubActions other than injected ones
+	RejectUnknownTriggers bool
+
 	parent              *Action
 	pathCached          string
 	subActionLookupTemp map[string]Action
 	subActionLookup     map[string]*Action
 	subActionTrigger    []string
 	helpTextCached      string
+	injected            bool
+	captureTrigger      string
+	customMatch         bool
 	finalized           bool
+
+	// finalizedFields records exported fields changed by Finalize(), with the values before and after Finalize()
+	finalizedFields map[string]fieldChange
 }
 
 // Help returns help text for this action
+// The help text is cached, unless HelpWidth is TerminalHelpWidth, as the terminal width may change
 func (act *Action) Help() string {
+	if act.HelpWidth == TerminalHelpWidth && act.HelpGen != nil {
+		return act.HelpGen(*act)
+	}
+
 	if act.helpTextCached == "" && act.HelpGen != nil {
 		act.helpTextCached = act.HelpGen(*act)
 	}
 	return act.helpTextCached
 }
 
+// helpWithState returns help text displayed during Parse()
+// SubActions disabled by State.DisabledCommands are hidden
+func (act *Action) helpWithState(state *State) string {
+	if act.HelpGenContext != nil {
+		return act.HelpGenContext(*act, state)
+	}
+
+	if visible := act.hideDisabled(state.DisabledCommands); visible != nil {
+		return act.HelpGen(*visible)
+	}
+	return act.Help()
+}
+
+// hideDisabled returns a copy of act without SubActions with Path() in disabled
+// nil is returned if no SubAction is disabled
+func (act *Action) hideDisabled(disabled map[string]bool) *Action {
+	triggers := []string{}
+	for _, trigger := range act.subActionTrigger {
+		if !disabled[act.subActionLookup[trigger].Path()] {
+			triggers = append(triggers, trigger)
+		}
+	}
+	if len(triggers) == len(act.subActionTrigger) {
+		return nil
+	}
+
+	visible := *act
+	visible.subActionTrigger = triggers
+	return &visible
+}
+
 // SubActions returns all immediate SubActions
 func (act Action) SubActions() []string {
 	return act.subActionTrigger
@@ -83,6 +397,140 @@ func (act Action) GetSubAction(trigger string) Action {
 	return *ret
 }
 
+// GetSubActionPtr retrieve the SubAction with Trigger is `trigger` used by the finalized Action tree
+// If there is no matched subaction, or this Action is not finalized yet, nil is returned
+// Modifying the returned Action after Finalize() is not supported
+func (act *Action) GetSubActionPtr(trigger string) *Action {
+	return act.subActionLookup[trigger]
+}
+
+// Find retrieves the Action at `path` under this Action
+// `path` is a list of Triggers separated by PathSeparator, one for each level below this Action
+// An empty `path` returns this Action itself
+// If there is no matched Action, an empty Action{} and false are returned
+func (act Action) Find(path string) (Action, bool) {
+	for _, trigger := range splitPath(path, act.pathSeparator()) {
+		if act.subActionLookup == nil {
+			sub, ok := act.subActionLookupTemp[trigger]
+			if !ok {
+				return Action{}, false
+			}
+			act = sub
+			continue
+		}
+		sub := act.subActionLookup[trigger]
+		if sub == nil {
+			return Action{}, false
+		}
+		act = *sub
+	}
+	return act, true
+}
+
+// splitPath splits path into Triggers by sep, empty Triggers and spaces around Triggers are dropped
+func splitPath(path string, sep string) []string {
+	if strings.TrimSpace(sep) == "" {
+		return strings.Fields(path)
+	}
+
+	triggers := []string{}
+	for _, trigger := range strings.Split(path, sep) {
+		if trigger = strings.TrimSpace(trigger); trigger != "" {
+			triggers = append(triggers, trigger)
+		}
+	}
+	return triggers
+}
+
+// PathNotFoundError indicates SubPath does not resolve to an Action under the Action with Path() `Path`
+type PathNotFoundError struct {
+	Err
+	Path    string
+	SubPath string
+}
+
+func (e PathNotFoundError) Error() string {
+	return fmt.Sprintf("Action %q is not found\nActionPath: %s", e.SubPath, e.Path)
+}
+
+func (PathNotFoundError) Code() string {
+	return "argo.path_not_found"
+}
+
+// HelpFor returns help text of the Action at `path` under this Action, `path` is resolved by Find()
+// `path` can also be Path() of the Action, which starts with Path() of this Action
+// An empty `path` returns help text of this Action itself
+func (act Action) HelpFor(path string) (string, error) {
+	if !act.finalized {
+		return "", ActionNotFinalizedError{Victim: act}
+	}
+
+	target, ok := act.Find(path)
+	if !ok && (path == act.Path() || strings.HasPrefix(path, act.Path()+act.pathSeparator())) {
+		target, ok = act.Find(strings.TrimPrefix(path, act.Path()))
+	}
+	if !ok {
+		return "", PathNotFoundError{Path: act.Path(), SubPath: path}
+	}
+	return target.Help(), nil
+}
+
+// matchTrigger checks if token triggers this Action
+func (act Action) matchTrigger(token string) bool {
+	if act.MatchFunc != nil {
+		return act.MatchFunc(act.Trigger, token)
+	}
+	return act.Trigger == token
+}
+
+// matchPhrase checks if leading args match Trigger with multiple words, and returns the number of matched args
+// Words are compared case-insensitively, and an arg may hold several words of the phrase
+func (act Action) matchPhrase(args []string) (int, bool) {
+	words := strings.Fields(act.Trigger)
+	if len(words) < 2 || act.MatchFunc != nil {
+		return 0, false
+	}
+
+	matched := 0
+	for index, arg := range args {
+		for _, word := range strings.Fields(arg) {
+			if matched >= len(words) || !strings.EqualFold(word, words[matched]) {
+				return 0, false
+			}
+			matched++
+		}
+
+		if matched == len(words) {
+			return index + 1, true
+		}
+	}
+	return 0, false
+}
+
+// consumeArgNames returns ArgNames of consumed args, excluding the name of captured arg
+func (act Action) consumeArgNames() []string {
+	if act.Capture && len(act.ArgNames) > 0 {
+		return act.ArgNames[1:]
+	}
+	return act.ArgNames
+}
+
+// argName returns the name of the consumed arg at index, or "argN" if it is not named
+func (act Action) argName(index int) string {
+	if argNames := act.consumeArgNames(); index < len(argNames) && argNames[index] != "" {
+		return argNames[index]
+	}
+	return fmt.Sprintf("arg%d", index+1)
+}
+
+// captureName returns the name used to store the captured arg
+func (act Action) captureName() string {
+	if len(act.ArgNames) > 0 {
+		return act.ArgNames[0]
+	}
+	return act.Trigger
+}
+
 // Path returns the arguments needed to trigger this action
 func (act Action) Path() string {
 	if act.pathCached == "" {
@@ -91,6 +539,21 @@ func (act Action) Path() string {
 	return act.pathCached
 }
 
+// pathSeparator returns PathSeparator of act or its nearest ancestor which sets it, or " " if none sets it
+func (act Action) pathSeparator() string {
+	for current := &act; current != nil; current = current.parent {
+		if current.PathSeparator != "" {
+			return current.PathSeparator
+		}
+	}
+	return " "
+}
+
+// subPath returns Path() of a SubAction of act with Trigger trigger
+func (act Action) subPath(trigger string) string {
+	return act.Path() + act.pathSeparator() + trigger
+}
+
 // EmptyTriggerError indicates an invalid Action which has empty Trigger string
 type EmptyTriggerError struct {
 	Err
@@ -101,6 +564,10 @@ func (e EmptyTriggerError) Error() string {
 	return fmt.Sprintf("Action with empty Trigger is not allowed. Path: %s", e.Path)
 }
 
+func (EmptyTriggerError) Code() string {
+	return "argo.empty_trigger"
+}
+
 // ActionAlreadyAssginedError indicates adding an Action which belongs to an ActionTree as SubAction
 type ActionAlreadyAssginedError struct {
 	Err
@@ -111,6 +578,10 @@ func (e ActionAlreadyAssginedError) Error() string {
 	return fmt.Sprintf("Action already belongs to an ActionTree\nActionPath: %s", e.AssignedPath)
 }
 
+func (ActionAlreadyAssginedError) Code() string {
+	return "argo.action_already_assigned"
+}
+
 // DuplicatedSubActionError indicates attempting to add a SubAction with Trigger
 // that is already in the sub action list
 type DuplicatedSubActionError struct {
@@ -122,6 +593,10 @@ func (e DuplicatedSubActionError) Error() string {
 	return fmt.Sprintf("SubAction Already Exists, Trigger: %s", e.Trigger)
 }
 
+func (DuplicatedSubActionError) Code() string {
+	return "argo.duplicated_sub_action"
+}
+
 // UnreachableActionError indicates an Action will never be reached due to its parent consumed all args
 type UnreachableActionError struct {
 	Err
@@ -132,8 +607,16 @@ func (e UnreachableActionError) Error() string {
 	return fmt.Sprintf("Action is unreachable: %s", e.Path)
 }
 
+func (UnreachableActionError) Code() string {
+	return "argo.unreachable_action"
+}
+
 // AddSubAction append an SubAction to handle further triggering args
 func (act *Action) AddSubAction(subAct Action) error {
+	if subAct.Capture && subAct.Trigger == "" && len(subAct.ArgNames) > 0 {
+		subAct.Trigger = "<" + subAct.ArgNames[0] + ">"
+	}
+
 	if subAct.Trigger == "" {
 		return EmptyTriggerError{}
 	}
@@ -142,8 +625,8 @@ func (act *Action) AddSubAction(subAct Action) error {
 		return ActionAlreadyAssginedError{AssignedPath: subAct.Path()}
 	}
 
-	if act.MaxConsume < 0 {
-		return UnreachableActionError{Path: act.Path() + " " + subAct.Trigger}
+	if act.MaxConsume < 0 && act.ConsumeUntil == "" {
+		return UnreachableActionError{Path: act.subPath(subAct.Trigger)}
 	}
 
 	if act.subActionLookupTemp == nil {
@@ -152,13 +635,75 @@ func (act *Action) AddSubAction(subAct Action) error {
 		return DuplicatedSubActionError{Trigger: subAct.Trigger}
 	}
 
+	if subAct.Capture {
+		if act.captureTrigger != "" {
+			return DuplicatedSubActionError{Trigger: subAct.Trigger}
+		}
+		act.captureTrigger = subAct.Trigger
+	}
+
 	subAct.parent = act
-	subAct.pathCached = subAct.parent.Path() + " " + subAct.Trigger
+	subAct.pathCached = subAct.parent.subPath(subAct.Trigger)
 	act.subActionTrigger = append(act.subActionTrigger, subAct.Trigger)
 	act.subActionLookupTemp[subAct.Trigger] = subAct
 	return nil
 }
 
+// SubActionFailure is an error returned when adding the SubAction at Index by AddSubActions()
+type SubActionFailure struct {
+	Index int
+	Cause error
+}
+
+// MultiError aggregates errors of AddSubActions() in the order of the given SubActions
+type MultiError struct {
+	Err
+	Failures []SubActionFailure
+}
+
+func (e MultiError) Error() string {
+	lines := []string{fmt.Sprintf("%d SubActions failed to be added", len(e.Failures))}
+	for _, failure := range e.Failures {
+		lines = append(lines, fmt.Sprintf("[%d] %s", failure.Index, failure.Cause))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (MultiError) Code() string {
+	return "argo.multi_error"
+}
+
+// AddSubActions appends SubActions in order as AddSubAction() does
+// A failure does not stop adding the following SubActions, all failures are returned in a MultiError
+func (act *Action) AddSubActions(subActs ...Action) error {
+	failures := []SubActionFailure{}
+	for index, subAct := range subActs {
+		if err := act.AddSubAction(subAct); err != nil {
+			failures = append(failures, SubActionFailure{Index: index, Cause: err})
+		}
+	}
+
+	if len(failures) > 0 {
+		return MultiError{Failures: failures}
+	}
+	return nil
+}
+
+// MaxDepthExceededError indicates an Action is deeper than MaxDepth in the Action tree
+type MaxDepthExceededError struct {
+	Err
+	Path     string
+	MaxDepth int
+}
+
+func (e MaxDepthExceededError) Error() string {
+	return fmt.Sprintf("Action tree is deeper than MaxDepth %d\nActionPath: %s", e.MaxDepth, e.Path)
+}
+
+func (MaxDepthExceededError) Code() string {
+	return "argo.max_depth_exceeded"
+}
+
 // ActionNotFinalizedError indicates Action APIs are called before Action is finalized
 type ActionNotFinalizedError struct {
 	Err
@@ -170,6 +715,10 @@ func (e ActionNotFinalizedError) Error() string {
 	return str
 }
 
+func (ActionNotFinalizedError) Code() string {
+	return "argo.action_not_finalized"
+}
+
 // DoubleFinalizeError indicates attempting to Finalize an Action second time.
 type DoubleFinalizeError struct {
 	Err
@@ -181,197 +730,1149 @@ func (e DoubleFinalizeError) Error() string {
 	return str
 }
 
-func defaultHelpGenerator(act Action) string {
-	text := strings.Builder{}
+func (DoubleFinalizeError) Code() string {
+	return "argo.double_finalize"
+}
 
-	text.WriteString("[Usage]\n")
-	genUsage := func(act Action) string {
-		text := strings.Builder{}
-		text.WriteString(act.Path())
+// ArgNamesMismatchError indicates an Action has more ArgNames than the args it can consume
+type ArgNamesMismatchError struct {
+	Err
+	Path       string
+	ArgNames   int
+	MaxConsume int
+}
 
-		if act.MaxConsume != 0 {
-			argNum := 0
-			if act.MaxConsume > 0 {
-				argNum = act.MaxConsume
-			} else {
-				argNum = act.MinConsume
-			}
+func (e ArgNamesMismatchError) Error() string {
+	return fmt.Sprintf("Action has %d ArgNames but consumes at most %d args: %s",
+		e.ArgNames, e.MaxConsume, e.Path)
+}
 
-			requiredArgs := make([]string, argNum)
-			if len(act.ArgNames) > 0 {
-				copy(requiredArgs, act.ArgNames)
-			}
+func (ArgNamesMismatchError) Code() string {
+	return "argo.arg_names_mismatch"
+}
 
-			for index, arg := range requiredArgs[:act.MinConsume] {
-				if arg == "" {
-					text.WriteString(fmt.Sprintf(" <%s%d>", "arg", index+1))
-				} else {
-					text.WriteString(fmt.Sprintf(" <%s>", arg))
-				}
-			}
+// DuplicateArgNameError indicates an Action has the same name more than once in ArgNames
+type DuplicateArgNameError struct {
+	Err
+	Path string
+	Name string
+}
 
-			if act.MaxConsume < 0 {
-				if len(act.ArgNames) > act.MinConsume {
-					text.WriteString(fmt.Sprintf(" [%s ...]", act.ArgNames[act.MinConsume]))
-				} else {
-					text.WriteString(" [argN ...]")
-				}
-			} else {
-				if act.MaxConsume > act.MinConsume {
-					text.WriteString(" [")
-					argText := strings.Builder{}
-					for index, arg := range requiredArgs[act.MinConsume:] {
-						if arg == "" {
-							argText.WriteString(fmt.Sprintf("%s%d ", "arg", index+act.MinConsume+1))
-						} else {
-							argText.WriteString(fmt.Sprintf("%s ", arg))
-						}
-					}
-					text.WriteString(strings.TrimSpace(argText.String()))
-					text.WriteString("]")
-				}
-			}
-		} else {
-			text.WriteString(" [sub-action]")
-		}
+func (e DuplicateArgNameError) Error() string {
+	return fmt.Sprintf("Duplicated ArgName %q in Action: %s", e.Name, e.Path)
+}
 
-		return text.String()
-	}
-	text.WriteString(genUsage(act))
+func (DuplicateArgNameError) Code() string {
+	return "argo.duplicate_arg_name"
+}
 
-	if act.LongDescr != "" {
-		text.WriteString("\n\n[Description]\n")
-		text.WriteString(fmt.Sprint(act.LongDescr))
-	} else if act.ShortDescr != "" {
-		text.WriteString("\n\n[Description]\n")
-		text.WriteString(fmt.Sprint(act.ShortDescr))
-	}
+// HelpShadowedError indicates a SubAction with the same Trigger as HelpTrigger shadows the help SubAction
+// This is only reported when StrictHelp is set
+type HelpShadowedError struct {
+	Err
+	Path    string
+	Trigger string
+}
 
-	subAct := act.SubActions()
-	if len(subAct) != 0 {
-		text.WriteString("\n\n[Sub-actions]")
-		for _, sub := range subAct {
-			subAct := act.GetSubAction(sub)
-			text.WriteString(fmt.Sprintf("\n%s\n- %s", subAct.Trigger, subAct.ShortDescr))
-		}
-	}
+func (e HelpShadowedError) Error() string {
+	return fmt.Sprintf("SubAction %q shadows the help SubAction of Action: %s", e.Trigger, e.Path)
+}
 
-	return text.String()
+func (HelpShadowedError) Code() string {
+	return "argo.help_shadowed"
 }
 
-func finalizeActionTree(parent *Action, act *Action) error {
-	if act.finalized {
-		return DoubleFinalizeError{Victim: *act}
-	}
+// HelpFlagConflictError indicates an option accepted by an Action is the same as its HelpFlag or HelpShortFlag
+type HelpFlagConflictError struct {
+	Err
+	Path string
+	Flag string
+}
 
-	if act.Trigger == "" {
-		return EmptyTriggerError{Path: act.Path()}
-	}
+func (e HelpFlagConflictError) Error() string {
+	return fmt.Sprintf("Option %s conflicts with the help flag of Action: %s", e.Flag, e.Path)
+}
 
-	// Retarget parent
-	act.parent = parent
+func (HelpFlagConflictError) Code() string {
+	return "argo.help_flag_conflict"
+}
 
-	// Normalize Min/MaxConsume settings
-	if act.MinConsume < 0 {
-		act.MinConsume = 0
-	}
+// TriggerLooksLikeFlagError indicates a SubAction has a Trigger starting with "-",
+// which cannot be told apart from options of an Action accepting options
+type TriggerLooksLikeFlagError struct {
+	Err
+	Path    string
+	Trigger string
+}
 
-	if act.MaxConsume >= 0 && act.MaxConsume < act.MinConsume {
-		act.MaxConsume = act.MinConsume
-	}
+func (e TriggerLooksLikeFlagError) Error() string {
+	return fmt.Sprintf("SubAction %q looks like an option of Action: %s", e.Trigger, e.Path)
+}
 
-	// Setup Path
-	if act.parent == nil {
-		act.pathCached = act.Trigger
-	} else {
-		act.pathCached = act.parent.Path() + " " + act.Trigger
+func (TriggerLooksLikeFlagError) Code() string {
+	return "argo.trigger_looks_like_flag"
+}
+
+const defaultHelpWidth = 80
+
+const defaultMaxDepth = 64
+
+const (
+	defaultHelpFlag      = "--help"
+	defaultHelpShortFlag = "-h"
+)
+
+const (
+	defaultVersionTrigger = "version"
+	versionFlag           = "--version"
+)
+
+const defaultCommandsTrigger = "commands"
+
+// TerminalHelpWidth can be assigned to HelpWidth to wrap help text to the width of the terminal
+const TerminalHelpWidth = -1
+
+// defaultTerminalWidth reads the terminal width from the COLUMNS environment variable
+func defaultTerminalWidth() int {
+	width, err := strconv.Atoi(os.Getenv("COLUMNS"))
+	if err != nil {
+		return 0
 	}
+	return width
+}
 
-	// Setup Help text
-	if act.HelpGen == nil {
-		if act.parent == nil {
-			act.HelpGen = defaultHelpGenerator
-		} else {
-			act.HelpGen = act.parent.HelpGen
-		}
+// helpWidth returns the width which help text should be wrapped to
+func (act Action) helpWidth() int {
+	if act.HelpWidth != TerminalHelpWidth {
+		return act.HelpWidth
 	}
 
-	// Inject help SubAction
-	if act.HelpTrigger == "" {
-		if act.parent == nil {
-			act.HelpTrigger = "help"
-		} else {
-			act.HelpTrigger = act.parent.HelpTrigger
-		}
+	width := 0
+	if act.TerminalWidth != nil {
+		width = act.TerminalWidth()
 	}
+	if width <= 0 {
+		return defaultHelpWidth
+	}
+	return width
+}
 
-	if !act.DisableHelp && act.MaxConsume == 0 {
-		err := act.AddSubAction(Action{
-			Trigger:    act.HelpTrigger,
-			MaxConsume: 1,
-			Do: func(state *State, _ ...interface{}) error {
-				args := state.Args()
-				if len(args) > 0 {
-					cmd := args[0]
-					targetAct := act.GetSubAction(cmd)
-					if targetAct.Trigger == "" {
-						fmt.Fprintf(&state.OutputStr, "Sub action not found: %s %s", act.Path(), cmd)
-					} else {
-						state.OutputStr.WriteString(targetAct.Help())
-					}
-				} else {
-					state.OutputStr.WriteString(act.Help())
-				}
-				return nil
-			},
-			ShortDescr:  "Display help for commands",
-			DisableHelp: true,
-		})
+// wideRanges are ranges of East Asian wide and fullwidth runes, which take 2 columns in terminals
+var wideRanges = []struct{ first, last rune }{
+	{0x1100, 0x115F}, {0x2E80, 0x303E}, {0x3041, 0x33FF}, {0x3400, 0x4DBF}, {0x4E00, 0x9FFF},
+	{0xA000, 0xA4CF}, {0xAC00, 0xD7A3}, {0xF900, 0xFAFF}, {0xFE30, 0xFE4F}, {0xFF00, 0xFF60},
+	{0xFFE0, 0xFFE6}, {0x1F300, 0x1F64F}, {0x1F900, 0x1F9FF}, {0x20000, 0x2FFFD}, {0x30000, 0x3FFFD},
+}
 
-		if err != nil {
-			_, helpExists := err.(DuplicatedSubActionError)
-			if !helpExists {
-				return err // should not reach
+// displayWidth returns the number of terminal columns taken by text
+// Wide runes take 2 columns, and combining marks and control runes take none
+func displayWidth(text string) int {
+	width := 0
+	for _, char := range text {
+		if unicode.Is(unicode.Mn, char) || unicode.IsControl(char) {
+			continue
+		}
+
+		width++
+		for _, wide := range wideRanges {
+			if char >= wide.first && char <= wide.last {
+				width++
+				break
 			}
 		}
 	}
+	return width
+}
 
-	// Create lookupTable
-	act.subActionLookup = make(map[string]*Action)
-	for subTrigger, subAct := range act.subActionLookupTemp {
-		tempAct := subAct
-		act.subActionLookup[subTrigger] = &tempAct
+// padRight pads text with spaces to take width terminal columns
+func padRight(text string, width int) string {
+	if pad := width - displayWidth(text); pad > 0 {
+		return text + strings.Repeat(" ", pad)
 	}
+	return text
+}
 
-	act.finalized = true
+// wrapText breaks each line of text on word boundaries so that it fits in width
+// The first line is prefixed with prefix, and the following lines are prefixed with indent
+// Lines fitting in width are kept as is, and width <= 0 disables wrapping
+func wrapText(text string, width int, prefix string, indent string) string {
+	lines := []string{}
+	for index, line := range strings.Split(text, "\n") {
+		lead := indent
+		if index == 0 {
+			lead = prefix
+		}
 
-	for _, subAct := range act.subActionLookup {
-		if err := finalizeActionTree(act, subAct); err != nil {
-			return err
+		if width <= 0 || displayWidth(lead+line) <= width {
+			lines = append(lines, lead+line)
+			continue
+		}
+
+		current := lead
+		empty := true
+		for _, word := range strings.Fields(line) {
+			if !empty && displayWidth(current+" "+word) > width {
+				lines = append(lines, current)
+				current = indent
+				empty = true
+			}
+
+			if empty {
+				current += word
+				empty = false
+			} else {
+				current += " " + word
+			}
 		}
+		lines = append(lines, current)
 	}
 
-	return nil
+	return strings.Join(lines, "\n")
 }
 
-// Finalize should be called after Action tree is created before calling Parse()
-// It initializes internal data for current Action and all SubActions for later Parse() calls
-// Finalize should be called only once
-// Do not attempt to modified any members of Actions in the Action tree after a Finalize() call
-func (act *Action) Finalize() error {
-	return finalizeActionTree(nil, act)
+// usageArg describes an argument placeholder shown in the usage section of help text
+type usageArg struct {
+	name     string
+	required bool
+	variadic bool
+	tail     bool
 }
 
-// TooFewArgsError indicates an Action is triggered with few args then Action.MinConsume
-type TooFewArgsError struct {
-	Err
-	Victim Action
-	Args   []string
+func (arg usageArg) String() string {
+	if arg.tail && arg.required {
+		return fmt.Sprintf("<%s...>", arg.name)
+	}
+	if arg.tail {
+		return fmt.Sprintf("[%s...]", arg.name)
+	}
+	if arg.required {
+		return fmt.Sprintf("<%s>", arg.name)
+	}
+	if arg.variadic {
+		return fmt.Sprintf("[%s ...]", arg.name)
+	}
+	return fmt.Sprintf("[%s]", arg.name)
+}
+
+// usageArgs returns the argument placeholders of act in consuming order
+func usageArgs(act Action) []usageArg {
+	argNames := act.consumeArgNames()
+	argName := act.argName
+
+	args := []usageArg{}
+	if act.MaxConsume < 0 && act.VariadicName != "" {
+		// Trailing args start after ArgNames, and are required if MinConsume covers the start
+		start := len(argNames)
+		for index := 0; index < start; index++ {
+			args = append(args, usageArg{name: argName(index), required: index < act.MinConsume})
+		}
+		return append(args, usageArg{
+			name:     act.VariadicName,
+			required: start < act.MinConsume,
+			variadic: true,
+			tail:     true,
+		})
+	}
+
+	for index := 0; index < act.MinConsume; index++ {
+		args = append(args, usageArg{name: argName(index), required: true})
+	}
+
+	if act.MaxConsume < 0 {
+		name := "argN"
+		if len(argNames) > act.MinConsume && argNames[act.MinConsume] != "" {
+			name = argNames[act.MinConsume]
+		}
+		args = append(args, usageArg{name: name, variadic: true})
+	} else {
+		for index := act.MinConsume; index < act.MaxConsume; index++ {
+			args = append(args, usageArg{name: argName(index)})
+		}
+	}
+
+	return args
+}
+
+// Usage returns the one-line synopsis of this Action, such as `cmd sub <arg1> [arg2]`
+func (act Action) Usage() string {
+	text := strings.Builder{}
+	text.WriteString(act.Path())
+
+	if len(act.allOptions()) > 0 {
+		text.WriteString(" [options]")
+	}
+
+	if act.MaxConsume == 0 {
+		text.WriteString(" [sub-action]")
+		return text.String()
+	}
+
+	args := usageArgs(act)
+	if act.MultilineUsage {
+		width := 0
+		for _, arg := range args {
+			if displayWidth(arg.String()) > width {
+				width = displayWidth(arg.String())
+			}
+		}
+
+		for _, arg := range args {
+			note := "optional"
+			if arg.required && arg.variadic {
+				note = "required, repeatable"
+			} else if arg.required {
+				note = "required"
+			} else if arg.variadic {
+				note = "optional, repeatable"
+			}
+			text.WriteString(fmt.Sprintf("\n  %s  (%s)", padRight(arg.String(), width), note))
+		}
+		return text.String()
+	}
+
+	optional := []string{}
+	for _, arg := range args {
+		if arg.variadic && len(optional) > 0 {
+			// Keep optional args in front of the trailing args
+			text.WriteString(fmt.Sprintf(" [%s]", strings.Join(optional, " ")))
+			optional = nil
+		}
+
+		if arg.required || arg.variadic {
+			text.WriteString(" " + arg.String())
+		} else {
+			optional = append(optional, arg.name)
+		}
+	}
+
+	if len(optional) > 0 {
+		text.WriteString(fmt.Sprintf(" [%s]", strings.Join(optional, " ")))
+	}
+
+	return text.String()
+}
+
+// helpSubActions returns SubActions of act to be listed in help text, Hidden SubActions are skipped
+func helpSubActions(act Action) []Action {
+	subActs := []Action{}
+	injected := []Action{}
+	for _, sub := range act.SubActions() {
+		subAct := act.GetSubAction(sub)
+		if subAct.Hidden {
+			continue
+		} else if act.SortSubActions && subAct.injected {
+			injected = append(injected, subAct)
+		} else {
+			subActs = append(subActs, subAct)
+		}
+	}
+
+	if act.SortSubActions {
+		sort.SliceStable(subActs, func(i, j int) bool {
+			return subActs[i].Trigger < subActs[j].Trigger
+		})
+		subActs = append(subActs, injected...)
+	}
+	if act.Default != nil && act.Default.Trigger != "" && !act.Default.Hidden {
+		subActs = append(subActs, *act.Default)
+	}
+	return subActs
+}
+
+// ANSI styles used in help text when HelpColor is enabled
+const (
+	ansiHeader  = "1"
+	ansiTrigger = "36"
+)
+
+// helpStyle wraps text with ANSI escape codes of style if act.HelpColor is enabled
+func helpStyle(act Action, text string, style string) string {
+	if !act.HelpColor {
+		return text
+	}
+	return "\x1b[" + style + "m" + text + "\x1b[0m"
+}
+
+func defaultHelpGenerator(act Action) string {
+	text := strings.Builder{}
+
+	text.WriteString(helpStyle(act, act.UsageTitle, ansiHeader) + "\n")
+	text.WriteString(act.Usage())
+
+	if act.LongDescr != "" {
+		text.WriteString("\n\n" + helpStyle(act, act.DescriptionTitle, ansiHeader) + "\n")
+		text.WriteString(wrapText(act.LongDescr, act.helpWidth(), "", ""))
+	} else if act.ShortDescr != "" {
+		text.WriteString("\n\n" + helpStyle(act, act.DescriptionTitle, ansiHeader) + "\n")
+		text.WriteString(wrapText(act.ShortDescr, act.helpWidth(), "", ""))
+	}
+
+	if len(act.Options) != 0 {
+		text.WriteString("\n\n" + helpStyle(act, act.OptionsTitle, ansiHeader))
+		for _, opt := range act.Options {
+			text.WriteString(fmt.Sprintf("\n%s\n%s", opt.String(),
+				wrapText(opt.Descr, act.helpWidth(), "- ", "  ")))
+		}
+	}
+
+	if globalOpts := act.globalOptions(); len(globalOpts) != 0 {
+		text.WriteString("\n\n" + helpStyle(act, act.GlobalOptionsTitle, ansiHeader))
+		for _, opt := range globalOpts {
+			text.WriteString(fmt.Sprintf("\n%s\n%s", opt.String(),
+				wrapText(opt.Descr, act.helpWidth(), "- ", "  ")))
+		}
+	}
+
+	subActs := helpSubActions(act)
+	if len(subActs) != 0 {
+		text.WriteString("\n\n" + helpStyle(act, act.SubActionsTitle, ansiHeader))
+		for _, subAct := range subActs {
+			trigger := helpStyle(act, subAct.Trigger, ansiTrigger)
+			if subAct.Deprecated != "" {
+				trigger += " (deprecated)"
+			}
+			text.WriteString(fmt.Sprintf("\n%s\n%s", trigger,
+				wrapText(subAct.ShortDescr, act.helpWidth(), "- ", "  ")))
+		}
+	}
+
+	return text.String()
+}
+
+// BadConsumeExprError indicates Consume of an Action is not a valid expression
+type BadConsumeExprError struct {
+	Err
+	Path    string
+	Consume string
+}
+
+func (e BadConsumeExprError) Error() string {
+	return fmt.Sprintf("Invalid Consume expression %q of Action: %s", e.Consume, e.Path)
+}
+
+func (BadConsumeExprError) Code() string {
+	return "argo.bad_consume_expr"
+}
+
+// ConsumeConflictError indicates an Action sets both Consume and MinConsume/MaxConsume
+type ConsumeConflictError struct {
+	Err
+	Path    string
+	Consume string
+}
+
+func (e ConsumeConflictError) Error() string {
+	return fmt.Sprintf("Consume %q conflicts with MinConsume/MaxConsume of Action: %s", e.Consume, e.Path)
+}
+
+func (ConsumeConflictError) Code() string {
+	return "argo.consume_conflict"
+}
+
+// parseConsume parses a Consume expression into MinConsume and MaxConsume
+func parseConsume(expr string) (int, int, bool) {
+	if expr == "*" {
+		return 0, -1, true
+	}
+
+	parseCount := func(str string) (int, bool) {
+		count, err := strconv.Atoi(str)
+		return count, err == nil && count >= 0 && !strings.HasPrefix(str, "+")
+	}
+
+	minStr, maxStr, isRange := strings.Cut(expr, "..")
+	minConsume, ok := parseCount(minStr)
+	if !ok {
+		return 0, 0, false
+	}
+
+	if !isRange {
+		return minConsume, minConsume, true
+	}
+
+	if maxStr == "" {
+		return minConsume, -1, true
+	}
+
+	maxConsume, ok := parseCount(maxStr)
+	if !ok || maxConsume < minConsume {
+		return 0, 0, false
+	}
+	return minConsume, maxConsume, true
+}
+
+// inferConsume sets MinConsume and MaxConsume of act from the syntax of its ArgNames
+// Bare names are required, names in brackets are optional and a trailing name ending with "..." consumes all remaining args
+// The syntax is removed from ArgNames, and the trailing name is moved to VariadicName
+// RequiredAfterOptionalError is returned if a required name follows an optional name
+func inferConsume(act *Action) error {
+	names := []string{}
+	required := true
+	for index, name := range act.ArgNames {
+		optional := strings.HasPrefix(name, "[") && strings.HasSuffix(name, "]")
+		if optional {
+			name = name[1 : len(name)-1]
+		}
+
+		if !optional && !required {
+			return RequiredAfterOptionalError{Path: act.Path(), Name: name}
+		} else if !optional {
+			act.MinConsume++
+		} else {
+			required = false
+		}
+
+		if index == len(act.ArgNames)-1 && strings.HasSuffix(name, "...") {
+			act.MaxConsume = -1
+			act.VariadicName = strings.TrimSuffix(name, "...")
+			act.ArgNames = names
+			return nil
+		}
+		names = append(names, name)
+	}
+
+	act.MaxConsume = len(names)
+	act.ArgNames = names
+	return nil
+}
+
+// RequiredAfterOptionalError indicates a required name follows an optional name in ArgNames,
+// when InferConsumeFromArgNames is set
+type RequiredAfterOptionalError struct {
+	Err
+	Path string
+	Name string
+}
+
+func (e RequiredAfterOptionalError) Error() string {
+	return fmt.Sprintf("Required ArgName %q follows an optional one in Action: %s", e.Name, e.Path)
+}
+
+func (RequiredAfterOptionalError) Code() string {
+	return "argo.required_after_optional"
+}
+
+func finalizeActionTree(parent *Action, act *Action) error {
+	if act.finalized {
+		return DoubleFinalizeError{Victim: *act}
+	}
+
+	isDefault := parent != nil && parent.Default == act
+	if act.Trigger == "" && !isDefault {
+		return EmptyTriggerError{Path: act.Path()}
+	}
+
+	explicit := *act
+
+	// Retarget parent
+	act.parent = parent
+
+	if act.Consume != "" {
+		if act.MinConsume != 0 || act.MaxConsume != 0 {
+			return ConsumeConflictError{Path: act.Path(), Consume: act.Consume}
+		}
+
+		minConsume, maxConsume, ok := parseConsume(act.Consume)
+		if !ok {
+			return BadConsumeExprError{Path: act.Path(), Consume: act.Consume}
+		}
+		act.MinConsume, act.MaxConsume = minConsume, maxConsume
+
+		if act.MaxConsume < 0 && act.ConsumeUntil == "" && len(act.subActionTrigger) > 0 {
+			return UnreachableActionError{Path: act.subPath(act.subActionTrigger[0])}
+		}
+	}
+
+	if act.parent != nil && act.parent.InferConsumeFromArgNames {
+		act.InferConsumeFromArgNames = true
+	}
+
+	if act.InferConsumeFromArgNames && act.Consume == "" && !act.Capture &&
+		act.MinConsume == 0 && act.MaxConsume == 0 && len(act.ArgNames) > 0 {
+		if err := inferConsume(act); err != nil {
+			return err
+		}
+	}
+
+	if isDefault {
+		if len(act.subActionTrigger) > 0 {
+			return UnreachableActionError{Path: act.parent.subPath(act.subActionTrigger[0])}
+		}
+		act.MaxConsume = -1
+	}
+
+	// Normalize Min/MaxConsume settings
+	if act.MinConsume < 0 {
+		act.MinConsume = 0
+	}
+
+	if act.MaxConsume >= 0 && act.MaxConsume < act.MinConsume {
+		act.MaxConsume = act.MinConsume
+	}
+
+	// Setup Path
+	if act.PathSeparator == "" {
+		act.PathSeparator = act.pathSeparator()
+	}
+
+	if act.parent == nil {
+		act.pathCached = act.Trigger
+	} else if act.Trigger == "" {
+		act.pathCached = act.parent.Path()
+	} else {
+		act.pathCached = act.parent.subPath(act.Trigger)
+	}
+
+	if act.MaxDepth == 0 {
+		if act.parent == nil {
+			act.MaxDepth = defaultMaxDepth
+		} else {
+			act.MaxDepth = act.parent.MaxDepth
+		}
+	}
+
+	depth := 1
+	for parent := act.parent; parent != nil; parent = parent.parent {
+		depth++
+	}
+	if depth > act.MaxDepth && !act.injected {
+		return MaxDepthExceededError{Path: act.Path(), MaxDepth: act.MaxDepth}
+	}
+
+	if act.MaxConsume >= 0 && len(act.consumeArgNames()) > act.MaxConsume {
+		return ArgNamesMismatchError{
+			Path:       act.Path(),
+			ArgNames:   len(act.consumeArgNames()),
+			MaxConsume: act.MaxConsume,
+		}
+	}
+
+	argNames := make(map[string]bool)
+	for _, name := range act.ArgNames {
+		if name == "" {
+			continue
+		}
+
+		if argNames[name] {
+			return DuplicateArgNameError{Path: act.Path(), Name: name}
+		}
+		argNames[name] = true
+	}
+
+	// Setup Help text
+	if act.HelpGen == nil {
+		if act.parent == nil {
+			act.HelpGen = defaultHelpGenerator
+		} else {
+			act.HelpGen = act.parent.HelpGen
+		}
+	}
+
+	titles := []*string{
+		&act.UsageTitle, &act.DescriptionTitle, &act.OptionsTitle, &act.GlobalOptionsTitle, &act.SubActionsTitle,
+	}
+	parentTitles := []string{"[Usage]", "[Description]", "[Options]", "[Global Options]", "[Sub-actions]"}
+	if act.parent != nil {
+		parentTitles = []string{
+			act.parent.UsageTitle, act.parent.DescriptionTitle, act.parent.OptionsTitle,
+			act.parent.GlobalOptionsTitle, act.parent.SubActionsTitle,
+		}
+	}
+	for index, title := range titles {
+		if *title == "" {
+			*title = parentTitles[index]
+		}
+	}
+
+	if act.HelpWidth == 0 {
+		if act.parent == nil {
+			act.HelpWidth = defaultHelpWidth
+		} else {
+			act.HelpWidth = act.parent.HelpWidth
+		}
+	}
+
+	if act.TerminalWidth == nil {
+		if act.parent == nil {
+			act.TerminalWidth = defaultTerminalWidth
+		} else {
+			act.TerminalWidth = act.parent.TerminalWidth
+		}
+	}
+
+	if act.parent != nil && act.parent.MultilineUsage {
+		act.MultilineUsage = true
+	}
+
+	if act.parent != nil && act.parent.CollectTimings {
+		act.CollectTimings = true
+	}
+
+	if act.parent != nil && act.parent.TransactionalOutput {
+		act.TransactionalOutput = true
+	}
+
+	if act.parent != nil && act.parent.RecoverPanics {
+		act.RecoverPanics = true
+	}
+
+	if act.UnknownFlagMode == FlagInherit {
+		if act.parent == nil {
+			act.UnknownFlagMode = FlagPassthrough
+		} else {
+			act.UnknownFlagMode = act.parent.UnknownFlagMode
+		}
+	}
+
+	if err := act.checkOptions(); err != nil {
+		return err
+	}
+
+	if len(act.allOptions()) > 0 || act.UnknownFlagMode != FlagPassthrough {
+		for _, trigger := range act.subActionTrigger {
+			if len(trigger) > 1 && trigger[0] == '-' {
+				return TriggerLooksLikeFlagError{Path: act.Path(), Trigger: trigger}
+			}
+		}
+	}
+
+	if act.parent != nil && act.parent.HelpColor {
+		act.HelpColor = true
+	}
+
+	if act.parent != nil && act.parent.SortSubActions {
+		act.SortSubActions = true
+	}
+
+	if act.parent != nil && act.parent.AllowAbbrev {
+		act.AllowAbbrev = true
+	}
+
+	if act.parent != nil && act.parent.HelpBeforeConsume {
+		act.HelpBeforeConsume = true
+	}
+
+	if act.ChainSeparator == "" && act.parent != nil {
+		act.ChainSeparator = act.parent.ChainSeparator
+	}
+
+	if act.NotFoundTemplate == nil && act.parent != nil {
+		act.NotFoundTemplate = act.parent.NotFoundTemplate
+	}
+
+	if act.DefaultHandler == nil && act.parent != nil {
+		act.DefaultHandler = act.parent.DefaultHandler
+	}
+
+	if act.OnUnmatched == nil && act.parent != nil {
+		act.OnUnmatched = act.parent.OnUnmatched
+	}
+
+	if act.parent != nil && act.parent.RejectUnknownTriggers {
+		act.RejectUnknownTriggers = true
+	}
+
+	if act.UsageStats == nil && act.parent != nil {
+		act.UsageStats = act.parent.UsageStats
+	}
+
+	if act.PromptFunc == nil && act.parent != nil {
+		act.PromptFunc = act.parent.PromptFunc
+	}
+
+	if act.Tracer == nil && act.parent != nil {
+		act.Tracer = act.parent.Tracer
+	}
+
+	if act.OnError == nil && act.parent != nil {
+		act.OnError = act.parent.OnError
+	}
+
+	if act.HelpDo == nil && act.parent != nil {
+		act.HelpDo = act.parent.HelpDo
+	}
+
+	if act.HelpGenContext == nil && act.parent != nil {
+		act.HelpGenContext = act.parent.HelpGenContext
+	}
+
+	if act.parent != nil && act.parent.StrictHelp {
+		act.StrictHelp = true
+	}
+
+	if act.HelpFlag == "" {
+		if act.parent == nil {
+			act.HelpFlag = defaultHelpFlag
+		} else {
+			act.HelpFlag = act.parent.HelpFlag
+		}
+	}
+
+	if act.HelpShortFlag == "" {
+		if act.parent == nil {
+			act.HelpShortFlag = defaultHelpShortFlag
+		} else {
+			act.HelpShortFlag = act.parent.HelpShortFlag
+		}
+	}
+
+	if !act.DisableHelp {
+		for _, opt := range act.allOptions() {
+			if "--"+opt.Name == act.HelpFlag || "--"+opt.Name == act.HelpShortFlag {
+				return HelpFlagConflictError{Path: act.Path(), Flag: "--" + opt.Name}
+			}
+			if opt.Short != "" && ("-"+opt.Short == act.HelpFlag || "-"+opt.Short == act.HelpShortFlag) {
+				return HelpFlagConflictError{Path: act.Path(), Flag: "-" + opt.Short}
+			}
+		}
+	}
+
+	// Inject version SubAction
+	if act.VersionTrigger == "" {
+		act.VersionTrigger = defaultVersionTrigger
+	}
+
+	if act.parent == nil && act.Version != "" && (act.MaxConsume >= 0 || act.ConsumeUntil != "") {
+		version := act.Version
+		err := act.AddSubAction(Action{
+			Trigger: act.VersionTrigger,
+			Do: func(state *State, _ ...interface{}) error {
+				state.OutputStr.WriteString(version)
+				return nil
+			},
+			ShortDescr:  "Display version",
+			DisableHelp: true,
+			injected:    true,
+		})
+
+		if _, overridden := err.(DuplicatedSubActionError); err != nil && !overridden {
+			return err // should not reach
+		}
+	}
+
+	// Inject commands SubAction
+	if act.CommandsTrigger == "" {
+		act.CommandsTrigger = defaultCommandsTrigger
+	}
+
+	if act.parent == nil && act.EnableCommandsList && (act.MaxConsume >= 0 || act.ConsumeUntil != "") {
+		err := act.AddSubAction(Action{
+			Trigger: act.CommandsTrigger,
+			Do: func(state *State, _ ...interface{}) error {
+				state.OutputStr.WriteString(commandsList(act.Index(false)))
+				return nil
+			},
+			ShortDescr:  "List all commands",
+			DisableHelp: true,
+			injected:    true,
+		})
+
+		if _, overridden := err.(DuplicatedSubActionError); err != nil && !overridden {
+			return err // should not reach
+		}
+	}
+
+	// Inject help SubAction
+	if act.HelpTrigger == "" {
+		if act.parent == nil {
+			act.HelpTrigger = "help"
+		} else {
+			act.HelpTrigger = act.parent.HelpTrigger
+		}
+	}
+
+	if act.HelpDepth == 0 && act.parent != nil {
+		act.HelpDepth = act.parent.HelpDepth
+	}
+
+	if !act.DisableHelp && act.MaxConsume == 0 {
+		helpMaxConsume := -1
+		if act.HelpDepth > 0 {
+			helpMaxConsume = act.HelpDepth
+		}
+
+		err := act.AddSubAction(Action{
+			Trigger:    act.HelpTrigger,
+			MaxConsume: helpMaxConsume,
+			Do: func(state *State, _ ...interface{}) error {
+				state.helpShown = true
+
+				// Walk down the tree with each arg to find the target, matching args as Parse() does
+				parent, target, token := act, act, ""
+				args := append([]string{}, state.Args()...)
+				for len(args) > 0 {
+					arg := args[0]
+					args = args[1:]
+
+					next, err := target.matchSubAction(arg, state.DisabledCommands)
+					if err != nil {
+						return err
+					}
+
+					if next == nil {
+						var split []string
+						next, split, err = target.matchJoinedSubAction(arg, state.DisabledCommands)
+						if err != nil {
+							return err
+						}
+						if next != nil {
+							arg, args = split[0], append(split[1:], args...)
+						}
+					}
+
+					parent, target, token = target, next, arg
+					if target == nil {
+						break
+					}
+				}
+
+				if act.HelpDo != nil {
+					act.HelpDo(state, act, target)
+					return nil
+				}
+
+				if target == nil && act.NotFoundTemplate != nil {
+					state.OutputStr.WriteString(act.NotFoundTemplate(parent.Path(), token))
+				} else if target == nil {
+					fmt.Fprintf(&state.OutputStr, "Sub action not found: %s%s%s", parent.Path(), parent.pathSeparator(), token)
+				} else {
+					state.OutputStr.WriteString(target.helpWithState(state))
+				}
+				return nil
+			},
+			ShortDescr:  "Display help for commands",
+			DisableHelp: true,
+			injected:    true,
+		})
+
+		if err != nil {
+			_, helpExists := err.(DuplicatedSubActionError)
+			if !helpExists {
+				return err // should not reach
+			}
+
+			if act.StrictHelp {
+				return HelpShadowedError{Path: act.Path(), Trigger: act.HelpTrigger}
+			}
+		}
+	}
+
+	act.finalizedFields = changedFields(explicit, *act)
+
+	// Create lookupTable
+	act.subActionLookup = make(map[string]*Action)
+	for subTrigger, subAct := range act.subActionLookupTemp {
+		tempAct := subAct
+		act.subActionLookup[subTrigger] = &tempAct
+		if subAct.MatchFunc != nil {
+			act.customMatch = true
+		}
+	}
+
+	act.finalized = true
+
+	for _, subTrigger := range act.subActionTrigger {
+		if err := finalizeActionTree(act, act.subActionLookup[subTrigger]); err != nil {
+			return err
+		}
+	}
+
+	if act.Default != nil {
+		defaultAct := *act.Default
+		act.Default = &defaultAct
+		if err := finalizeActionTree(act, act.Default); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Finalize should be called after Action tree is created before calling Parse()
+// It initializes internal data for current Action and all SubActions for later Parse() calls
+// Finalize should be called only once
+// Do not attempt to modified any members of Actions in the Action tree after a Finalize() call
+func (act *Action) Finalize() error {
+	if act.GlobalUniqueTriggers {
+		if err := checkGlobalTriggers(*act); err != nil {
+			return err
+		}
+	}
+
+	return finalizeActionTree(nil, act)
+}
+
+// Finalized checks if Finalize() has been called on this Action
+func (act Action) Finalized() bool {
+	return act.finalized
+}
+
+// Refinalize rebuilds the finalized Action tree, so that changes made after Finalize() take effect
+// SubActions added by AddSubAction() after Finalize() are picked up, and injected help SubActions are recreated
+// Settings inherited from parent, set to defaults or derived, such as bounds inferred from ArgNames,
+// in the previous Finalize() are set again, unless they are changed after Finalize()
+func (act *Action) Refinalize() error {
+	resetFinalize(act)
+	return act.Finalize()
+}
+
+// fieldChange is an exported field of Action changed by Finalize()
+type fieldChange struct {
+	explicit  reflect.Value
+	finalized reflect.Value
+}
+
+// sameFieldValue checks if a and b are the same value of an Action field, funcs are compared by their code pointers
+func sameFieldValue(a reflect.Value, b reflect.Value) bool {
+	if a.Kind() == reflect.Func {
+		return a.Pointer() == b.Pointer()
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// changedFields returns exported fields, except Default, which are different in explicit and finalized
+func changedFields(explicit Action, finalized Action) map[string]fieldChange {
+	changes := make(map[string]fieldChange)
+	explicitValue, finalizedValue := reflect.ValueOf(explicit), reflect.ValueOf(finalized)
+	for index := 0; index < explicitValue.NumField(); index++ {
+		field := explicitValue.Type().Field(index)
+		if !field.IsExported() || field.Name == "Default" {
+			continue
+		}
+
+		if !sameFieldValue(explicitValue.Field(index), finalizedValue.Field(index)) {
+			changes[field.Name] = fieldChange{explicitValue.Field(index), finalizedValue.Field(index)}
+		}
+	}
+	return changes
+}
+
+// resetFinalize clears data derived by Finalize() in the Action tree of act
+func resetFinalize(act *Action) {
+	triggers := []string{}
+	temp := make(map[string]Action)
+	for _, trigger := range act.subActionTrigger {
+		subAct := act.subActionLookupTemp[trigger]
+		if finalized := act.subActionLookup[trigger]; finalized != nil {
+			subAct = *finalized
+		}
+
+		if subAct.injected {
+			continue
+		}
+
+		resetFinalize(&subAct)
+		triggers = append(triggers, trigger)
+		temp[trigger] = subAct
+	}
+
+	act.subActionTrigger = triggers
+	act.subActionLookupTemp = temp
+	act.subActionLookup = nil
+	act.helpTextCached = ""
+	act.customMatch = false
+	act.finalized = false
+
+	// Fields changed by Finalize() are restored to be inherited or derived again, unless they are changed after Finalize()
+	actValue := reflect.ValueOf(act).Elem()
+	for name, change := range act.finalizedFields {
+		field := actValue.FieldByName(name)
+		if sameFieldValue(field, change.finalized) {
+			field.Set(change.explicit)
+		}
+	}
+	act.finalizedFields = nil
+
+	if act.Default != nil {
+		defaultAct := *act.Default
+		resetFinalize(&defaultAct)
+		act.Default = &defaultAct
+	}
+}
+
+// GlobalTriggerCollisionError indicates a Trigger is used by more than one Action in the tree
+// This is only reported when GlobalUniqueTriggers is set
+type GlobalTriggerCollisionError struct {
+	Err
+	Trigger string
+	Paths   []string
+}
+
+func (e GlobalTriggerCollisionError) Error() string {
+	return fmt.Sprintf("Trigger %q is used by multiple Actions: %s", e.Trigger, strings.Join(e.Paths, ", "))
+}
+
+func (GlobalTriggerCollisionError) Code() string {
+	return "argo.global_trigger_collision"
+}
+
+// checkGlobalTriggers checks that every Trigger in the Action tree of root is used only once
+func checkGlobalTriggers(root Action) error {
+	triggers := []string{}
+	paths := make(map[string][]string)
+
+	var walk func(act Action, path string)
+	walk = func(act Action, path string) {
+		if _, ok := paths[act.Trigger]; !ok {
+			triggers = append(triggers, act.Trigger)
+		}
+		paths[act.Trigger] = append(paths[act.Trigger], path)
+
+		for _, trigger := range act.SubActions() {
+			sub := act.GetSubAction(trigger)
+			if !sub.Capture {
+				walk(sub, path+act.pathSeparator()+sub.Trigger)
+			}
+		}
+	}
+	walk(root, root.Trigger)
+
+	for _, trigger := range triggers {
+		if len(paths[trigger]) > 1 {
+			return GlobalTriggerCollisionError{Trigger: trigger, Paths: paths[trigger]}
+		}
+	}
+	return nil
+}
+
+// TooFewArgsError indicates an Action is triggered with few args then Action.MinConsume
+// MissingIndex is the index of the first missing arg, and MissingName is its name from ArgNames if available
+type TooFewArgsError struct {
+	Err
+	Victim       Action
+	Args         []string
+	MissingIndex int
+	MissingName  string
 }
 
 func (e TooFewArgsError) Error() string {
-	return fmt.Sprintf("Parsing Error: Too Few Arguments: %s\nActionPath: %s",
-		e.Args, (&e.Victim).Path())
+	str := fmt.Sprintf("Parsing Error: Too Few Arguments: %s\nActionPath: %s",
+		redactArgs(e.Victim, e.Args), (&e.Victim).Path())
+	if e.MissingName != "" {
+		str += fmt.Sprintf("\nMissing required argument: <%s>", e.MissingName)
+	}
+	return str
+}
+
+func (TooFewArgsError) Code() string {
+	return "argo.too_few_args"
+}
+
+// redactArgs returns a copy of args consumed by act with sensitive args replaced by "***"
+func redactArgs(act Action, args []string) []string {
+	ret := make([]string, len(args))
+	for index, arg := range args {
+		ret[index] = redactArg(act.SensitiveArgs, index, arg)
+	}
+	return ret
+}
+
+// redactArg returns "***" if the arg at index is marked in sensitive, or arg otherwise
+func redactArg(sensitive []bool, index int, arg string) string {
+	if index < len(sensitive) && sensitive[index] {
+		return "***"
+	}
+	return arg
 }
 
 // NilStateError indicates calling Action.Parse with state == nil
@@ -383,11 +1884,21 @@ func (NilStateError) Error() string {
 	return "Calling Parse() with state == nil"
 }
 
+func (NilStateError) Code() string {
+	return "argo.nil_state"
+}
+
 // Parse args with current Action and all SubActions
 // A state object needs to be provided to keep the states while visiting SubActions
 // state is also used to retrieve string outputs from triggered SubActions
 // optionally specified vargs will be forwarded to all Action.Do() calls
+// Options stored in state by the previous Parse() call are cleared
 func (act Action) Parse(state *State, args []string, vargs ...interface{}) error {
+	return act.parseWithFlags(state, args, nil, vargs)
+}
+
+// parseWithFlags works as Parse(), with option values in flags stored into state before parsing
+func (act Action) parseWithFlags(state *State, args []string, flags map[string]string, vargs []interface{}) error {
 	if !act.finalized {
 		return ActionNotFinalizedError{Victim: act}
 	}
@@ -400,42 +1911,636 @@ func (act Action) Parse(state *State, args []string, vargs ...interface{}) error
 		return NilStateError{}
 	}
 
-	if act.Trigger == args[0] {
-		// Action is triggered
-		// Consume args
-		if len(args[1:]) < act.MinConsume {
-			// Not enough arguments
-			return TooFewArgsError{
-				Victim: act,
-				Args:   args[1:],
+	state.options = nil
+	for name, value := range flags {
+		state.setOption(name, value, false)
+	}
+
+	if act.Preprocess != nil {
+		var err error
+		if args, err = act.Preprocess(args); err != nil {
+			if act.OnError != nil && !state.dryRun {
+				act.OnError(state, err)
 			}
+			return err
 		}
 
-		if act.MaxConsume < 0 || len(args[1:]) <= act.MaxConsume {
-			state.doArgs = args[1:]
-			// all args are consumed
-			if act.Do != nil {
-				return act.Do(state, vargs...)
-			}
+		if len(args) == 0 {
 			return nil
 		}
+	}
 
-		state.doArgs = args[1 : act.MaxConsume+1]
-		args = args[act.MaxConsume+1:]
-		if act.Do != nil {
-			err := act.Do(state, vargs...)
-			if err != nil {
-				return err
+	if act.LeadingAssignments {
+		args = state.takeAssignments(args)
+		if len(args) == 0 {
+			return nil
+		}
+	}
+
+	if phrase, ok := act.matchPhrase(args); ok {
+		args = append([]string{act.Trigger}, args[phrase:]...)
+	} else if !act.matchTrigger(args[0]) {
+		return nil
+	}
+
+	state.chained = false
+	state.vargs = vargs
+	outputLen := state.OutputStr.Len()
+	err := act.parse(state, args, vargs)
+	if err != nil && act.ErrorClearsOutput {
+		state.truncateOutput(outputLen)
+	}
+
+	if err != nil && act.OnError != nil && !state.dryRun {
+		act.OnError(state, err)
+	}
+	return err
+}
+
+// NoMatchingRootError indicates args match the Trigger of none of the roots given to ParseAny()
+type NoMatchingRootError struct {
+	Err
+	Arg string
+}
+
+func (e NoMatchingRootError) Error() string {
+	return fmt.Sprintf("Parsing Error: No Action is triggered by %q", e.Arg)
+}
+
+func (NoMatchingRootError) Code() string {
+	return "argo.no_matching_root"
+}
+
+// ParseAny parses args with the first Action in roots whose Trigger matches args[0], as Parse() does
+// NoMatchingRootError is returned if no Action in roots matches
+// Preprocess and LeadingAssignments of roots are applied only after the matching root is chosen
+func ParseAny(state *State, args []string, roots []Action, vargs ...interface{}) error {
+	for _, root := range roots {
+		if !root.finalized {
+			return ActionNotFinalizedError{Victim: root}
+		}
+	}
+
+	if len(args) == 0 {
+		return NoMatchingRootError{}
+	}
+
+	for _, root := range roots {
+		if _, ok := root.matchPhrase(args); ok || root.matchTrigger(args[0]) {
+			return root.Parse(state, args, vargs...)
+		}
+	}
+	return NoMatchingRootError{Arg: args[0]}
+}
+
+// ActionPanicError indicates Do of an Action panicked, and the panic is recovered by RecoverPanics
+type ActionPanicError struct {
+	Err
+	Path  string
+	Value interface{}
+}
+
+func (e ActionPanicError) Error() string {
+	return fmt.Sprintf("Action panicked: %v\nActionPath: %s", e.Value, e.Path)
+}
+
+func (ActionPanicError) Code() string {
+	return "argo.action_panic"
+}
+
+// callDo calls do of act, a panic in do is returned as ActionPanicError if RecoverPanics is set
+func (act *Action) callDo(do func(*State, ...interface{}) error, state *State, vargs []interface{}) (err error) {
+	if act.RecoverPanics {
+		defer func() {
+			if value := recover(); value != nil {
+				err = ActionPanicError{Path: act.Path(), Value: value}
 			}
+		}()
+	}
+
+	return do(state, vargs...)
+}
+
+// TraceKind is the kind of a TraceEvent
+type TraceKind int
+
+const (
+	// TraceMatched is emitted when an Action is triggered, Args holds the triggering arg
+	TraceMatched TraceKind = iota
+	// TraceConsumed is emitted when an Action consumed args, Args holds the consumed args
+	TraceConsumed
+	// TraceDispatched is emitted when the remaining args trigger a SubAction or Default
+	// Args holds the triggering arg and Next is the Path() of the triggered Action
+	TraceDispatched
+	// TraceError is emitted when an Action fails, Err holds the error
+	TraceError
+)
+
+// TraceEvent describes a decision made by Parse(), Path is the Path() of the current Action
+type TraceEvent struct {
+	Kind TraceKind
+	Path string
+	Args []string
+	Next string
+	Err  error
+}
+
+// trace emits event to Tracer if it is set, events are not emitted by DryRun()
+func (act *Action) trace(state *State, event TraceEvent) {
+	if act.Tracer != nil && !state.dryRun {
+		act.Tracer(event)
+	}
+}
+
+// parse runs the triggered act and all following triggered SubActions level by level
+func (act *Action) parse(state *State, args []string, vargs []interface{}) error {
+	// Output length before the first triggered Action with TransactionalOutput
+	rollback := -1
+	maxDepth := act.MaxDepth
+	for depth := 1; act != nil; depth++ {
+		if depth > maxDepth && !act.injected {
+			return MaxDepthExceededError{Path: act.Path(), MaxDepth: maxDepth}
 		}
 
-		// Try to trigger SubActions with next arg
-		if subAct, ok := act.subActionLookup[args[0]]; ok {
-			return subAct.Parse(state, args, vargs...)
+		if act.TransactionalOutput && rollback < 0 {
+			rollback = state.OutputStr.Len()
 		}
 
-		return nil
+		var start time.Time
+		if act.CollectTimings {
+			start = time.Now()
+		}
+
+		act.trace(state, TraceEvent{Kind: TraceMatched, Path: act.Path(), Args: args[:1]})
+		next, remain, err := act.run(state, args, vargs)
+
+		if act.CollectTimings {
+			state.timings = append(state.timings, LevelTiming{
+				Path:     act.Path(),
+				Duration: time.Since(start),
+			})
+		}
+
+		if err != nil {
+			act.trace(state, TraceEvent{Kind: TraceError, Path: act.Path(), Err: err})
+			if rollback >= 0 {
+				state.truncateOutput(rollback)
+			}
+			return err
+		}
+		act, args = next, remain
 	}
 
 	return nil
 }
+
+// run consumes args and calls Do of the triggered act, args[0] is the triggering arg
+// It returns the SubAction triggered by the remaining args, or nil if there is none
+// In DryRun(), Triggers of triggered Actions are recorded into state instead of calling Do or writing any output
+func (act *Action) run(state *State, args []string, vargs []interface{}) (*Action, []string, error) {
+	if state.dryRun {
+		if act.Trigger != "" {
+			state.dryPath = append(state.dryPath, act.Trigger)
+		}
+		state.doArgs = nil
+	}
+
+	if act.showHelpBeforeConsume(args) {
+		if !state.dryRun {
+			state.OutputStr.WriteString(act.helpWithState(state))
+			state.helpShown = true
+		}
+		return nil, nil, nil
+	}
+
+	if act.showVersion(args) {
+		if !state.dryRun {
+			state.OutputStr.WriteString(act.Version)
+		}
+		return nil, nil, nil
+	}
+
+	args, err := act.takeOptions(state, args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Prompted args are not taken from args, so they are not counted as consumed
+	prompted := 0
+	var prompt func(argName string) (string, error)
+	if act.PromptFunc != nil && !state.dryRun {
+		prompt = func(argName string) (string, error) {
+			prompted++
+			return act.PromptFunc(argName)
+		}
+	}
+
+	consumed, args, err := act.consumeArgs(args, state.DisabledCommands, prompt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	act.trace(state, TraceEvent{Kind: TraceConsumed, Path: act.Path(), Args: redactArgs(*act, consumed)})
+	state.path = act.Path()
+	state.consumedCount = len(consumed) - prompted
+	state.doArgs = act.fillArgEnv(consumed)
+	state.argNames = act.consumeArgNames()
+	state.sensitiveArgs = act.SensitiveArgs
+
+	// The deprecation warning is a part of the output of act, so that ChainSeparator is inserted before it
+	outputLen := state.OutputStr.Len()
+	if act.Deprecated != "" && !state.dryRun {
+		name := act.Trigger
+		if name == "" {
+			name = act.Path()
+		}
+		state.OutputStr.WriteString(fmt.Sprintf("Warning: %q is deprecated: %s\n", name, act.Deprecated))
+	}
+
+	// The next Action is found before Do(), so that Do() is not called when args are rejected
+	next, nextArgs, err := act.nextAction(args, state.DisabledCommands)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if next == nil && len(args) > 0 && act.RejectUnknownTriggers && act.hasOwnSubActions() {
+		err := UnknownTriggerError{
+			Path:       act.Path(),
+			Arg:        redactArg(act.SensitiveArgs, len(consumed), args[0]),
+			Candidates: act.subActionChoices(state.DisabledCommands, true),
+		}
+		if act.NotFoundTemplate != nil {
+			err.Message = act.NotFoundTemplate(err.Path, err.Arg)
+		}
+		return nil, nil, err
+	}
+
+	if next == nil && act.RequireSubAction {
+		return nil, nil, SubActionRequiredError{
+			Path:    act.Path(),
+			Choices: act.subActionChoices(state.DisabledCommands, false),
+		}
+	}
+
+	do := act.Do
+	if do == nil && act.DefaultHandler != nil && isInvocable(*act) {
+		do = func(state *State, vargs ...interface{}) error {
+			return act.DefaultHandler(state, act, vargs...)
+		}
+	}
+
+	if do == nil || state.dryRun {
+		state.chainOutput(outputLen, act.ChainSeparator)
+	} else {
+		err := act.callDo(do, state, vargs)
+		state.chainOutput(outputLen, act.ChainSeparator)
+
+		if errors.Is(err, ErrShowHelp) {
+			state.OutputStr.WriteString(act.helpWithState(state))
+			state.helpShown = true
+			return nil, nil, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if next == nil && len(args) > 0 && act.OnUnmatched != nil && !state.dryRun {
+		act.OnUnmatched(args, act)
+	}
+
+	args = nextArgs
+	if next != nil {
+		act.trace(state, TraceEvent{Kind: TraceDispatched, Path: act.Path(), Args: args[:1], Next: next.Path()})
+	}
+	if next != nil && next.Capture && next != act.Default {
+		state.setCapture(next.captureName(), args[0])
+	}
+	return next, args, nil
+}
+
+// SubActionRequiredError indicates none of the SubActions is triggered after an Action with RequireSubAction
+type SubActionRequiredError struct {
+	Err
+	Path    string
+	Choices []string
+}
+
+func (e SubActionRequiredError) Error() string {
+	return fmt.Sprintf("Parsing Error: A SubAction is required, choices: %s\nActionPath: %s",
+		strings.Join(e.Choices, ", "), e.Path)
+}
+
+func (SubActionRequiredError) Code() string {
+	return "argo.sub_action_required"
+}
+
+// UnknownTriggerError indicates Arg triggers none of the SubActions of an Action with RejectUnknownTriggers set
+// Candidates are Triggers of the SubActions which could be triggered instead, including the injected ones
+// Message is generated by NotFoundTemplate if it is set, and it is used as the error message
+type UnknownTriggerError struct {
+	Err
+	Path       string
+	Arg        string
+	Candidates []string
+	Message    string
+}
+
+func (e UnknownTriggerError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return fmt.Sprintf("Parsing Error: Unknown argument %q, candidates: %s\nActionPath: %s",
+		e.Arg, strings.Join(e.Candidates, ", "), e.Path)
+}
+
+func (UnknownTriggerError) Code() string {
+	return "argo.unknown_trigger"
+}
+
+// hasOwnSubActions checks if act has SubActions other than injected ones
+func (act *Action) hasOwnSubActions() bool {
+	for _, trigger := range act.subActionTrigger {
+		if !act.subActionLookup[trigger].injected {
+			return true
+		}
+	}
+	return false
+}
+
+// subActionChoices returns Triggers of SubActions which can be triggered after act, excluding Hidden SubActions
+// and SubActions with Path() in disabled. Injected SubActions are included only if includeInjected is true
+// Triggers are ordered by UsageStats if it is set
+func (act *Action) subActionChoices(disabled map[string]bool, includeInjected bool) []string {
+	choices := []string{}
+	for _, trigger := range act.subActionTrigger {
+		subAct := act.subActionLookup[trigger]
+		if !subAct.Hidden && (includeInjected || !subAct.injected) && !disabled[subAct.Path()] {
+			choices = append(choices, trigger)
+		}
+	}
+	act.sortByUsage(choices)
+	return choices
+}
+
+// sortByUsage orders Triggers of SubActions of act by UsageStats, most used first, if UsageStats is set
+func (act *Action) sortByUsage(triggers []string) {
+	if act.UsageStats == nil {
+		return
+	}
+
+	usage := make(map[string]int)
+	for _, trigger := range triggers {
+		usage[trigger] = act.UsageStats(act.subActionLookup[trigger].Path())
+	}
+	sort.SliceStable(triggers, func(i, j int) bool {
+		return usage[triggers[i]] > usage[triggers[j]]
+	})
+}
+
+// showHelpBeforeConsume checks if help of act should be shown instead of consuming args
+// This happens when HelpFlag or HelpShortFlag is in the args which act would consume or the arg following them,
+// before `--` or ConsumeUntil,
+// or when the first arg is HelpTrigger with HelpBeforeConsume enabled
+// Options of act and their values are skipped, as they are not consumed args
+func (act *Action) showHelpBeforeConsume(args []string) bool {
+	if act.DisableHelp {
+		return false
+	}
+
+	if act.HelpBeforeConsume && act.MaxConsume != 0 && len(args) > 1 && args[1] == act.HelpTrigger {
+		return true
+	}
+
+	positional := 0
+	for index := 1; index < len(args); index++ {
+		arg := args[index]
+		if arg == "--" || (act.MaxConsume < 0 && act.ConsumeUntil != "" && arg == act.ConsumeUntil) {
+			break
+		}
+
+		if arg == act.HelpFlag || arg == act.HelpShortFlag {
+			return true
+		}
+
+		if found, err := act.matchOptions(arg); err == nil && found != nil {
+			for _, item := range found {
+				if !item.hasValue {
+					index++
+				}
+			}
+			continue
+		}
+
+		positional++
+		if act.MaxConsume >= 0 && positional > act.MaxConsume {
+			break
+		}
+	}
+	return false
+}
+
+// showVersion checks if the root act is invoked with "--version" to show Version
+func (act *Action) showVersion(args []string) bool {
+	return act.parent == nil && act.Version != "" && len(args) > 1 && args[1] == versionFlag
+}
+
+// consumeArgs returns args consumed by act and the remaining args, args[0] is the triggering arg
+// SubActions with Path() in disabled do not stop consuming with BacktrackForSubAction
+// If prompt is not nil, it is called for each missing required arg instead of returning TooFewArgsError
+func (act *Action) consumeArgs(args []string, disabled map[string]bool,
+	prompt func(argName string) (string, error)) ([]string, []string, error) {
+	var untilRemain []string
+	if act.MaxConsume < 0 && act.ConsumeUntil != "" {
+		for index, arg := range args[1:] {
+			if arg == act.ConsumeUntil {
+				// Args following ConsumeUntil are left for SubActions
+				args, untilRemain = args[:index+1], args[index+2:]
+				break
+			}
+		}
+	}
+
+	if len(args[1:]) < act.MinConsume && prompt != nil {
+		consumed := append([]string{}, args[1:]...)
+		for index := len(consumed); index < act.MinConsume; index++ {
+			value, err := prompt(act.argName(index))
+			if err != nil {
+				return nil, nil, err
+			}
+			consumed = append(consumed, value)
+		}
+		return consumed, untilRemain, nil
+	}
+
+	if len(args[1:]) < act.MinConsume {
+		// Not enough arguments
+		missing := len(args[1:])
+		missingName := ""
+		if argNames := act.consumeArgNames(); missing < len(argNames) {
+			missingName = argNames[missing]
+		}
+		return nil, nil, TooFewArgsError{
+			Victim:       *act,
+			Args:         args[1:],
+			MissingIndex: missing,
+			MissingName:  missingName,
+		}
+	}
+
+	consume := len(args[1:])
+	if act.MaxConsume >= 0 && consume > act.MaxConsume {
+		consume = act.MaxConsume
+	}
+
+	if act.BacktrackForSubAction {
+		// Optional args yield to the first arg which triggers a SubAction
+		for index := act.MinConsume; index < consume; index++ {
+			if subAct, _ := act.matchSubAction(args[index+1], disabled); subAct != nil && !subAct.Capture {
+				consume = index
+				break
+			}
+		}
+	}
+
+	// Limit capacity so that appending args from environment does not overwrite args
+	consumed := args[1 : consume+1 : consume+1]
+	remain := args[consume+1:]
+	if untilRemain != nil {
+		remain = append(remain[:len(remain):len(remain)], untilRemain...)
+	}
+	return consumed, remain, nil
+}
+
+// fillArgEnv appends args missing from consumed with values of environment variables in ArgEnv
+func (act *Action) fillArgEnv(consumed []string) []string {
+	for index := len(consumed); act.MaxConsume < 0 || index < act.MaxConsume; index++ {
+		name, ok := act.ArgEnv[index]
+		if !ok {
+			break
+		}
+
+		value := os.Getenv(name)
+		if value == "" {
+			break
+		}
+		consumed = append(consumed, value)
+	}
+	return consumed
+}
+
+// nextAction returns the SubAction or Default triggered by the remaining args, or nil if there is none
+// SubActions with Path() in disabled are skipped
+func (act *Action) nextAction(args []string, disabled map[string]bool) (*Action, []string, error) {
+	if len(args) == 0 {
+		// all args are consumed
+		return nil, nil, nil
+	}
+
+	// Try to trigger SubActions with next arg
+	subAct, err := act.matchSubAction(args[0], disabled)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if subAct != nil {
+		return subAct, args, nil
+	}
+
+	subAct, split, err := act.matchJoinedSubAction(args[0], disabled)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if subAct != nil {
+		return subAct, append(split, args[1:]...), nil
+	}
+
+	if act.Default != nil {
+		// Default consumes all remaining args, args[0] is kept as its triggering arg
+		return act.Default, append([]string{args[0]}, args...), nil
+	}
+
+	return nil, nil, nil
+}
+
+// matchJoinedSubAction returns the SubAction triggered by the leading part of arg joined with PathSeparator,
+// such as `db` of `db:migrate`, and arg split into the leading part and the remaining part
+// nil is returned if PathSeparator is " ", or either part is empty
+func (act *Action) matchJoinedSubAction(arg string, disabled map[string]bool) (*Action, []string, error) {
+	sep := act.pathSeparator()
+	index := strings.Index(arg, sep)
+	if sep == " " || index <= 0 || index+len(sep) == len(arg) {
+		return nil, nil, nil
+	}
+
+	subAct, err := act.matchSubAction(arg[:index], disabled)
+	if err != nil || subAct == nil {
+		return nil, nil, err
+	}
+	return subAct, []string{arg[:index], arg[index+len(sep):]}, nil
+}
+
+// AmbiguousTriggerError indicates an abbreviated arg matches more than one SubAction
+type AmbiguousTriggerError struct {
+	Err
+	Path       string
+	Arg        string
+	Candidates []string
+}
+
+func (e AmbiguousTriggerError) Error() string {
+	return fmt.Sprintf("Parsing Error: Ambiguous argument %q, candidates: %s\nActionPath: %s",
+		e.Arg, strings.Join(e.Candidates, ", "), e.Path)
+}
+
+func (AmbiguousTriggerError) Code() string {
+	return "argo.ambiguous_trigger"
+}
+
+// matchSubAction returns the SubAction triggered by arg, or nil if there is none
+// SubActions with Path() in disabled are skipped
+func (act *Action) matchSubAction(arg string, disabled map[string]bool) (*Action, error) {
+	enabled := func(trigger string) bool {
+		return !disabled[act.subActionLookup[trigger].Path()]
+	}
+
+	capture := func() *Action {
+		if act.captureTrigger == "" || !enabled(act.captureTrigger) {
+			return nil
+		}
+		return act.subActionLookup[act.captureTrigger]
+	}
+
+	if act.customMatch {
+		// Some SubActions have MatchFunc, check each SubAction in registration order
+		for _, trigger := range act.subActionTrigger {
+			subAct := act.subActionLookup[trigger]
+			if trigger != act.captureTrigger && subAct.matchTrigger(arg) && enabled(trigger) {
+				return subAct, nil
+			}
+		}
+	} else if subAct, ok := act.subActionLookup[arg]; ok && enabled(arg) {
+		return subAct, nil
+	}
+
+	if !act.AllowAbbrev || arg == "" {
+		return capture(), nil
+	}
+
+	candidates := []string{}
+	for _, trigger := range act.subActionTrigger {
+		if trigger != act.captureTrigger && strings.HasPrefix(trigger, arg) && enabled(trigger) {
+			candidates = append(candidates, trigger)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return capture(), nil
+	case 1:
+		return act.subActionLookup[candidates[0]], nil
+	default:
+		act.sortByUsage(candidates)
+		return nil, AmbiguousTriggerError{Path: act.Path(), Arg: arg, Candidates: candidates}
+	}
+}
@@ -2,6 +2,7 @@ package argo
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
 	"strings"
 	"testing"
@@ -1118,3 +1119,2122 @@ func TestNothingIsTriggered(t *testing.T) {
 	err = act.Parse(state, []string{"test1", "arg", "arg", "arg"})
 	checkEq(t, err, nil)
 }
+
+func TestHelpDo(t *testing.T) {
+	var gotAct, gotTarget string
+	act := Action{
+		Trigger: "cmd",
+		HelpDo: func(state *State, act *Action, target *Action) {
+			gotAct = act.Path()
+			gotTarget = target.Path()
+			state.OutputStr.WriteString("custom")
+		},
+	}
+
+	act.AddSubAction(Action{
+		Trigger:    "sub",
+		ShortDescr: "Short descr",
+	})
+
+	act.Finalize()
+	state := &State{}
+	err := act.Parse(state, []string{"cmd", "help", "sub"})
+	checkEq(t, err, nil)
+	checkEq(t, gotAct, "cmd")
+	checkEq(t, gotTarget, "cmd sub")
+	checkEq(t, state.OutputStr.String(), "custom")
+}
+
+func TestHelpDoNotFound(t *testing.T) {
+	called := false
+	act := Action{
+		Trigger: "cmd",
+		HelpDo: func(_ *State, _ *Action, target *Action) {
+			called = true
+			checkEq(t, target == nil, true)
+		},
+	}
+
+	act.Finalize()
+	act.Parse(&State{}, []string{"cmd", "help", "none"})
+	checkEq(t, called, true)
+}
+
+func TestGetSubActionPtr(t *testing.T) {
+	root := Action{Trigger: "root"}
+	sub := Action{Trigger: "sub"}
+	sub.AddSubAction(Action{Trigger: "subsub"})
+	root.AddSubAction(sub)
+
+	checkEq(t, root.GetSubActionPtr("sub") == nil, true)
+
+	var helpAct *Action
+	root.HelpDo = func(_ *State, act *Action, _ *Action) {
+		helpAct = act
+	}
+	root.Finalize()
+
+	subPtr := root.GetSubActionPtr("sub")
+	checkNe(t, subPtr, nil)
+	checkEq(t, subPtr.Path(), "root sub")
+	checkEq(t, subPtr.GetSubActionPtr("subsub").Path(), "root sub subsub")
+	checkEq(t, root.GetSubActionPtr("none") == nil, true)
+
+	root.Parse(&State{}, []string{"root", "sub", "help"})
+	checkEq(t, helpAct == subPtr, true)
+}
+
+func TestHelpMultilineUsage(t *testing.T) {
+	act := Action{
+		Trigger:        "cmd",
+		ShortDescr:     "descr",
+		MultilineUsage: true,
+	}
+
+	act.AddSubAction(Action{
+		Trigger:    "sub",
+		ShortDescr: "Short descr",
+		ArgNames:   []string{"src", "dst", "mode"},
+		MinConsume: 2,
+		MaxConsume: 4,
+	})
+
+	act.Finalize()
+	state := &State{}
+	act.Parse(state, []string{"cmd", "help", "sub"})
+
+	checkEq(t, state.OutputStr.String(),
+		`[Usage]
+cmd sub
+  <src>   (required)
+  <dst>   (required)
+  [mode]  (optional)
+  [arg4]  (optional)
+
+[Description]
+Short descr`)
+}
+
+func TestHelpWrap(t *testing.T) {
+	act := Action{
+		Trigger: "cmd",
+		LongDescr: "This is a very long description which is definitely longer than " +
+			"eighty characters so it should be wrapped.",
+		DisableHelp: true,
+	}
+
+	act.AddSubAction(Action{
+		Trigger: "sub",
+		ShortDescr: "A short description of sub which is still long enough to exceed " +
+			"the width of the terminal.",
+	})
+
+	act.Finalize()
+	checkEq(t, act.Help(),
+		`[Usage]
+cmd [sub-action]
+
+[Description]
+This is a very long description which is definitely longer than eighty
+characters so it should be wrapped.
+
+[Sub-actions]
+sub
+- A short description of sub which is still long enough to exceed the width of
+  the terminal.`)
+}
+
+func TestHelpWrapNewline(t *testing.T) {
+	act := Action{
+		Trigger:     "cmd",
+		LongDescr:   "first line\n  second line is kept as is",
+		DisableHelp: true,
+		MaxConsume:  1,
+	}
+
+	act.Finalize()
+	checkEq(t, act.Help(),
+		`[Usage]
+cmd [arg1]
+
+[Description]
+first line
+  second line is kept as is`)
+}
+
+func TestHelpWrapWidth(t *testing.T) {
+	act := Action{
+		Trigger:   "cmd",
+		LongDescr: "wrap this text\nat twelve",
+		HelpWidth: 12,
+	}
+
+	act.AddSubAction(Action{
+		Trigger:    "sub",
+		ShortDescr: "sub description text",
+	})
+
+	act.Finalize()
+	checkEq(t, act.GetSubActionPtr("sub").HelpWidth, 12)
+	checkEq(t, act.Help(),
+		`[Usage]
+cmd [sub-action]
+
+[Description]
+wrap this
+text
+at twelve
+
+[Sub-actions]
+sub
+- sub
+  description
+  text
+help
+- Display
+  help for
+  commands`)
+}
+
+func TestArgNamesMismatchError(t *testing.T) {
+	act := Action{
+		Trigger: "cmd",
+	}
+
+	act.AddSubAction(Action{
+		Trigger:    "sub",
+		ArgNames:   []string{"a1", "a2", "a3"},
+		MinConsume: 1,
+		MaxConsume: 2,
+	})
+
+	err := act.Finalize()
+	argoErr, ok := err.(ArgNamesMismatchError)
+	checkEq(t, ok, true)
+	checkEq(t, argoErr.Path, "cmd sub")
+	checkEq(t, argoErr.ArgNames, 3)
+	checkEq(t, argoErr.MaxConsume, 2)
+	checkEq(t, strings.Contains(argoErr.Error(), "cmd sub"), true)
+}
+
+func TestArgNamesConsumeAll(t *testing.T) {
+	act := Action{
+		Trigger:    "cmd",
+		ArgNames:   []string{"a1", "a2", "a3"},
+		MinConsume: 1,
+		MaxConsume: -1,
+	}
+
+	checkEq(t, act.Finalize(), nil)
+}
+
+func TestDefaultSubAction(t *testing.T) {
+	act := Action{
+		Trigger: "cmd",
+		Default: &Action{
+			ShortDescr: "open file",
+			Do: func(state *State, _ ...interface{}) error {
+				state.OutputStr.WriteString("default " + strings.Join(state.Args(), ","))
+				return nil
+			},
+		},
+	}
+
+	act.AddSubAction(Action{
+		Trigger: "sub",
+		Do: func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString("sub")
+			return nil
+		},
+	})
+
+	checkEq(t, act.Finalize(), nil)
+	checkEq(t, act.Default.Path(), "cmd")
+
+	state := &State{}
+	checkEq(t, act.Parse(state, []string{"cmd", "sub"}), nil)
+	checkEq(t, state.OutputStr.String(), "sub")
+
+	state = &State{}
+	checkEq(t, act.Parse(state, []string{"cmd", "file.txt", "sub"}), nil)
+	checkEq(t, state.OutputStr.String(), "default file.txt,sub")
+
+	checkEq(t, strings.Contains(act.Help(), "open file"), false)
+}
+
+func TestDefaultSubActionHelp(t *testing.T) {
+	act := Action{
+		Trigger: "cmd",
+		Default: &Action{
+			Trigger:    "<file>",
+			ShortDescr: "open file",
+		},
+	}
+
+	checkEq(t, act.Finalize(), nil)
+	checkEq(t, act.Default.Path(), "cmd <file>")
+	checkEq(t, act.Help(),
+		`[Usage]
+cmd [sub-action]
+
+[Sub-actions]
+help
+- Display help for commands
+<file>
+- open file`)
+}
+
+func TestSensitiveArgs(t *testing.T) {
+	act := Action{
+		Trigger:       "login",
+		ArgNames:      []string{"password", "user", "host"},
+		SensitiveArgs: []bool{true},
+		MinConsume:    3,
+		Do: func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString(state.Args()[0])
+			return nil
+		},
+	}
+	checkEq(t, act.Finalize(), nil)
+
+	err := act.Parse(&State{}, []string{"login", "secret", "me"})
+	argoErr, ok := err.(TooFewArgsError)
+	checkEq(t, ok, true)
+	checkEq(t, argoErr.Args, []string{"secret", "me"})
+	checkEq(t, strings.Contains(argoErr.Error(), "secret"), false)
+	checkEq(t, strings.Contains(argoErr.Error(), "[*** me]"), true)
+
+	state := &State{}
+	checkEq(t, act.Parse(state, []string{"login", "secret", "me", "local"}), nil)
+	checkEq(t, state.OutputStr.String(), "secret")
+
+	// Traces and conversion errors are redacted as well
+	events := []TraceEvent{}
+	pin := Action{
+		Trigger:       "pin",
+		ArgNames:      []string{"code"},
+		SensitiveArgs: []bool{true},
+		MinConsume:    1,
+		Tracer: func(event TraceEvent) {
+			events = append(events, event)
+		},
+		Do: func(state *State, _ ...interface{}) error {
+			_, err := state.ArgsInt()
+			return err
+		},
+	}
+	checkEq(t, pin.Finalize(), nil)
+	err = pin.Parse(&State{}, []string{"pin", "12ab"})
+	checkEq(t, err, ArgConversionError{Path: "pin", Index: 0, Value: "***", Type: "int"})
+	checkEq(t, events[1].Kind, TraceConsumed)
+	checkEq(t, events[1].Args, []string{"***"})
+
+	// A disabled SubAction does not stop consuming, so its Trigger is consumed as a sensitive arg
+	events = []TraceEvent{}
+	vault := Action{
+		Trigger:               "vault",
+		ArgNames:              []string{"token"},
+		SensitiveArgs:         []bool{true},
+		MaxConsume:            1,
+		BacktrackForSubAction: true,
+		RejectUnknownTriggers: true,
+		Tracer: func(event TraceEvent) {
+			events = append(events, event)
+		},
+	}
+	vault.AddSubAction(Action{Trigger: "seal"})
+	checkEq(t, vault.Finalize(), nil)
+	err = vault.Parse(&State{DisabledCommands: map[string]bool{"vault seal": true}}, []string{"vault", "seal"})
+	checkEq(t, err, nil)
+	checkEq(t, events[1].Kind, TraceConsumed)
+	checkEq(t, events[1].Args, []string{"***"})
+}
+
+func TestTooFewArgsErrorMissing(t *testing.T) {
+	act := Action{
+		Trigger:    "cp",
+		MinConsume: 2,
+	}
+	checkEq(t, act.Finalize(), nil)
+
+	err := act.Parse(&State{}, []string{"cp", "a"})
+	argoErr, ok := err.(TooFewArgsError)
+	checkEq(t, ok, true)
+	checkEq(t, argoErr.MissingIndex, 1)
+	checkEq(t, argoErr.MissingName, "")
+	checkEq(t, strings.Contains(argoErr.Error(), "Missing"), false)
+
+	act = Action{
+		Trigger:    "cp",
+		ArgNames:   []string{"source", "dest"},
+		MinConsume: 2,
+	}
+	checkEq(t, act.Finalize(), nil)
+
+	err = act.Parse(&State{}, []string{"cp", "a"})
+	argoErr, ok = err.(TooFewArgsError)
+	checkEq(t, ok, true)
+	checkEq(t, argoErr.MissingIndex, 1)
+	checkEq(t, argoErr.MissingName, "dest")
+	checkEq(t, strings.Contains(argoErr.Error(), "Missing required argument: <dest>"), true)
+}
+
+func TestArgEnv(t *testing.T) {
+	act := Action{
+		Trigger:    "connect",
+		MinConsume: 1,
+		MaxConsume: 3,
+		ArgEnv:     map[int]string{1: "ARGO_TEST_PORT", 2: "ARGO_TEST_USER"},
+		Do: func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString(strings.Join(state.Args(), ","))
+			return nil
+		},
+	}
+	checkEq(t, act.Finalize(), nil)
+
+	t.Setenv("ARGO_TEST_PORT", "")
+	t.Setenv("ARGO_TEST_USER", "admin")
+	state := &State{}
+	checkEq(t, act.Parse(state, []string{"connect", "host"}), nil)
+	checkEq(t, state.OutputStr.String(), "host")
+
+	t.Setenv("ARGO_TEST_PORT", "22")
+	state = &State{}
+	checkEq(t, act.Parse(state, []string{"connect", "host"}), nil)
+	checkEq(t, state.OutputStr.String(), "host,22,admin")
+
+	args := []string{"connect", "host", "80", "next"}
+	state = &State{}
+	checkEq(t, act.Parse(state, args[:3]), nil)
+	checkEq(t, state.OutputStr.String(), "host,80,admin")
+	checkEq(t, args[3], "next")
+}
+
+func TestErrShowHelp(t *testing.T) {
+	act := Action{
+		Trigger:    "cmd",
+		ShortDescr: "descr",
+		MaxConsume: 1,
+		Do: func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString("invalid input\n")
+			return ErrShowHelp
+		},
+	}
+	act.AddSubAction(Action{
+		Trigger: "sub",
+		Do: func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString("sub")
+			return nil
+		},
+	})
+	checkEq(t, act.Finalize(), nil)
+
+	state := &State{}
+	checkEq(t, act.Parse(state, []string{"cmd", "x", "sub"}), nil)
+	checkEq(t, state.OutputStr.String(), "invalid input\n"+act.Help())
+
+	// Wrapped ErrShowHelp shows help as well
+	act.Do = func(state *State, _ ...interface{}) error {
+		return fmt.Errorf("invalid input %q: %w", state.Args()[0], ErrShowHelp)
+	}
+	checkEq(t, act.Refinalize(), nil)
+
+	state = &State{}
+	checkEq(t, act.Parse(state, []string{"cmd", "x"}), nil)
+	checkEq(t, state.OutputStr.String(), act.Help())
+}
+
+func TestUsage(t *testing.T) {
+	act := Action{Trigger: "cmd"}
+	act.AddSubAction(Action{Trigger: "all", MinConsume: 2, MaxConsume: -1})
+	act.AddSubAction(Action{Trigger: "named", ArgNames: []string{"c1", "c2"}, MinConsume: 2, MaxConsume: -1})
+	act.AddSubAction(Action{Trigger: "max", ArgNames: []string{"c1", "c2", "c3", "c4"}, MinConsume: 2, MaxConsume: 4})
+	act.AddSubAction(Action{Trigger: "inf", ArgNames: []string{"c1", "c2", "c3", "c4"}, MinConsume: 2, MaxConsume: -1})
+	act.AddSubAction(Action{Trigger: "partial", ArgNames: []string{"c1"}, MinConsume: 2, MaxConsume: -1})
+	act.AddSubAction(Action{Trigger: "optional", ArgNames: []string{"c1"}, MinConsume: 2, MaxConsume: 5})
+	checkEq(t, act.Finalize(), nil)
+
+	checkEq(t, act.Usage(), "cmd [sub-action]")
+	checkEq(t, act.GetSubAction("all").Usage(), "cmd all <arg1> <arg2> [argN ...]")
+	checkEq(t, act.GetSubAction("named").Usage(), "cmd named <c1> <c2> [argN ...]")
+	checkEq(t, act.GetSubAction("max").Usage(), "cmd max <c1> <c2> [c3 c4]")
+	checkEq(t, act.GetSubAction("inf").Usage(), "cmd inf <c1> <c2> [c3 ...]")
+	checkEq(t, act.GetSubAction("partial").Usage(), "cmd partial <c1> <arg2> [argN ...]")
+	checkEq(t, act.GetSubAction("optional").Usage(), "cmd optional <c1> <arg2> [arg3 arg4 arg5]")
+}
+
+func TestHelpRoot(t *testing.T) {
+	act := Action{
+		Trigger:    "cmd",
+		ShortDescr: "the program",
+	}
+	act.AddSubAction(Action{Trigger: "build", ShortDescr: "Build things"})
+	act.AddSubAction(Action{Trigger: "test", ShortDescr: "Test things", MaxConsume: 1})
+	act.AddSubAction(Action{Trigger: "debug", ShortDescr: "Internal", Hidden: true})
+	checkEq(t, act.Finalize(), nil)
+
+	full := `[Usage]
+cmd [sub-action]
+
+[Description]
+the program
+
+[Sub-actions]
+build
+- Build things
+test
+- Test things
+help
+- Display help for commands`
+
+	state := &State{}
+	checkEq(t, act.Parse(state, []string{"cmd", "help"}), nil)
+	checkEq(t, state.OutputStr.String(), full)
+	checkEq(t, strings.Contains(state.OutputStr.String(), "debug"), false)
+
+	state = &State{}
+	checkEq(t, act.Parse(state, []string{"cmd", "--help"}), nil)
+	checkEq(t, state.OutputStr.String(), full)
+
+	// The configured HelpFlag is used instead of "--help"
+	act.HelpFlag = "--usage"
+	checkEq(t, act.Refinalize(), nil)
+	state = &State{}
+	checkEq(t, act.Parse(state, []string{"cmd", "--usage"}), nil)
+	checkEq(t, state.OutputStr.String(), full)
+
+	state = &State{}
+	checkEq(t, act.Parse(state, []string{"cmd", "help", "test"}), nil)
+	checkEq(t, state.OutputStr.String(), `[Usage]
+cmd test [arg1]
+
+[Description]
+Test things`)
+}
+
+func TestAllowAbbrev(t *testing.T) {
+	output := func(str string) func(*State, ...interface{}) error {
+		return func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString(str)
+			return nil
+		}
+	}
+
+	act := Action{
+		Trigger:     "git",
+		AllowAbbrev: true,
+	}
+	act.AddSubAction(Action{Trigger: "commit", Do: output("commit")})
+	act.AddSubAction(Action{Trigger: "config", Do: output("config")})
+	act.AddSubAction(Action{Trigger: "co", Do: output("co")})
+	act.AddSubAction(Action{Trigger: "status", Do: output("status")})
+	checkEq(t, act.Finalize(), nil)
+
+	state := &State{}
+	checkEq(t, act.Parse(state, []string{"git", "comm"}), nil)
+	checkEq(t, state.OutputStr.String(), "commit")
+
+	state = &State{}
+	checkEq(t, act.Parse(state, []string{"git", "st"}), nil)
+	checkEq(t, state.OutputStr.String(), "status")
+
+	state = &State{}
+	checkEq(t, act.Parse(state, []string{"git", "co"}), nil)
+	checkEq(t, state.OutputStr.String(), "co")
+
+	state = &State{}
+	err := act.Parse(state, []string{"git", "con"})
+	checkEq(t, err, nil)
+	checkEq(t, state.OutputStr.String(), "config")
+
+	err = act.Parse(&State{}, []string{"git", "c"})
+	argoErr, ok := err.(AmbiguousTriggerError)
+	checkEq(t, ok, true)
+	checkEq(t, argoErr.Candidates, []string{"commit", "config", "co"})
+	checkEq(t, strings.Contains(argoErr.Error(), "commit, config, co"), true)
+
+	state = &State{}
+	checkEq(t, act.Parse(state, []string{"git", "x"}), nil)
+	checkEq(t, state.OutputStr.String(), "")
+
+	state = &State{}
+	checkEq(t, act.Parse(state, []string{"git", "he"}), nil)
+	checkEq(t, state.OutputStr.String(), act.Help())
+}
+
+func TestOnError(t *testing.T) {
+	calls := 0
+	root := Action{
+		Trigger: "root",
+		OnError: func(state *State, err error) {
+			calls++
+			state.OutputStr.WriteString("failed: " + err.Error())
+		},
+	}
+	sub := Action{Trigger: "sub"}
+	sub.AddSubAction(Action{
+		Trigger: "leaf",
+		Do: func(_ *State, _ ...interface{}) error {
+			return CustomError{}
+		},
+	})
+	sub.AddSubAction(Action{Trigger: "args", MinConsume: 1})
+	root.AddSubAction(sub)
+	checkEq(t, root.Finalize(), nil)
+
+	state := &State{}
+	err := root.Parse(state, []string{"root", "sub", "leaf"})
+	checkTypeEq(t, err, CustomError{})
+	checkEq(t, calls, 1)
+	checkEq(t, state.OutputStr.String(), "failed: cerr")
+
+	calls = 0
+	err = root.Parse(&State{}, []string{"root", "sub", "args"})
+	checkTypeEq(t, err, TooFewArgsError{})
+	checkEq(t, calls, 1)
+
+	calls = 0
+	checkEq(t, root.Parse(&State{}, []string{"root", "sub"}), nil)
+	checkEq(t, calls, 0)
+}
+
+func TestHelpBeforeConsume(t *testing.T) {
+	newAct := func(helpBeforeConsume bool) Action {
+		act := Action{
+			Trigger:           "cmd",
+			HelpBeforeConsume: helpBeforeConsume,
+		}
+		act.AddSubAction(Action{
+			Trigger:    "echo",
+			ShortDescr: "Echo args",
+			MaxConsume: -1,
+			Do: func(state *State, _ ...interface{}) error {
+				state.OutputStr.WriteString(strings.Join(state.Args(), " "))
+				return nil
+			},
+		})
+		act.Finalize()
+		return act
+	}
+
+	act := newAct(false)
+	state := &State{}
+	checkEq(t, act.Parse(state, []string{"cmd", "echo", "help"}), nil)
+	checkEq(t, state.OutputStr.String(), "help")
+
+	act = newAct(true)
+	state = &State{}
+	checkEq(t, act.Parse(state, []string{"cmd", "echo", "help"}), nil)
+	checkEq(t, state.OutputStr.String(), `[Usage]
+cmd echo [argN ...]
+
+[Description]
+Echo args`)
+
+	state = &State{}
+	checkEq(t, act.Parse(state, []string{"cmd", "echo", "a", "help"}), nil)
+	checkEq(t, state.OutputStr.String(), "a help")
+}
+
+func TestCaptureSubAction(t *testing.T) {
+	root := Action{Trigger: "user"}
+	name := Action{
+		Capture:    true,
+		ArgNames:   []string{"name"},
+		ShortDescr: "Select user",
+	}
+	name.AddSubAction(Action{
+		Trigger: "show",
+		Do: func(state *State, _ ...interface{}) error {
+			value, ok := state.Capture("name")
+			checkEq(t, ok, true)
+			state.OutputStr.WriteString("show " + value)
+			return nil
+		},
+	})
+	checkEq(t, root.AddSubAction(name), nil)
+	checkEq(t, root.AddSubAction(Action{
+		Trigger: "list",
+		Do: func(state *State, _ ...interface{}) error {
+			_, ok := state.Capture("name")
+			checkEq(t, ok, false)
+			state.OutputStr.WriteString("list")
+			return nil
+		},
+	}), nil)
+
+	err := root.AddSubAction(Action{Capture: true, ArgNames: []string{"other"}})
+	checkTypeEq(t, err, DuplicatedSubActionError{})
+	checkEq(t, root.Finalize(), nil)
+	checkEq(t, root.GetSubAction("<name>").GetSubAction("show").Path(), "user <name> show")
+
+	state := &State{}
+	checkEq(t, root.Parse(state, []string{"user", "alice", "show"}), nil)
+	checkEq(t, state.OutputStr.String(), "show alice")
+
+	state = &State{}
+	checkEq(t, root.Parse(state, []string{"user", "list", "show"}), nil)
+	checkEq(t, state.OutputStr.String(), "list")
+
+	checkEq(t, strings.Contains(root.Help(), "<name>\n- Select user"), true)
+}
+
+func TestCaptureSubActionConsume(t *testing.T) {
+	root := Action{Trigger: "set", AllowAbbrev: true}
+	root.AddSubAction(Action{Trigger: "verbose"})
+	root.AddSubAction(Action{
+		Capture:    true,
+		ArgNames:   []string{"key", "value"},
+		MinConsume: 1,
+		Do: func(state *State, _ ...interface{}) error {
+			key, _ := state.Capture("key")
+			state.OutputStr.WriteString(key + "=" + state.Args()[0])
+			return nil
+		},
+	})
+	checkEq(t, root.Finalize(), nil)
+	checkEq(t, root.GetSubAction("<key>").Usage(), "set <key> <value>")
+
+	state := &State{}
+	checkEq(t, root.Parse(state, []string{"set", "color", "red"}), nil)
+	checkEq(t, state.OutputStr.String(), "color=red")
+
+	state = &State{}
+	checkEq(t, root.Parse(state, []string{"set", "verb"}), nil)
+	checkEq(t, state.OutputStr.String(), "")
+
+	err := root.Parse(&State{}, []string{"set", "color"})
+	argoErr, ok := err.(TooFewArgsError)
+	checkEq(t, ok, true)
+	checkEq(t, argoErr.MissingName, "value")
+}
+
+func TestChainSeparator(t *testing.T) {
+	output := func(str string) func(*State, ...interface{}) error {
+		return func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString(str)
+			return nil
+		}
+	}
+
+	root := Action{
+		Trigger:        "root",
+		ChainSeparator: "\n",
+		Do:             output("root"),
+	}
+	sub := Action{Trigger: "sub", Do: output("")}
+	sub.AddSubAction(Action{Trigger: "leaf", Do: output("leaf")})
+	root.AddSubAction(sub)
+	checkEq(t, root.Finalize(), nil)
+
+	state := &State{}
+	state.OutputStr.WriteString("before:")
+	checkEq(t, root.Parse(state, []string{"root", "sub", "leaf"}), nil)
+	checkEq(t, state.OutputStr.String(), "before:root\nleaf")
+
+	checkEq(t, root.Parse(state, []string{"root"}), nil)
+	checkEq(t, state.OutputStr.String(), "before:root\nleafroot")
+}
+
+func TestDuplicateArgNameError(t *testing.T) {
+	act := Action{Trigger: "cmd"}
+	act.AddSubAction(Action{
+		Trigger:    "sub",
+		ArgNames:   []string{"a", "", "", "a"},
+		MaxConsume: 4,
+	})
+
+	err := act.Finalize()
+	argoErr, ok := err.(DuplicateArgNameError)
+	checkEq(t, ok, true)
+	checkEq(t, argoErr.Path, "cmd sub")
+	checkEq(t, argoErr.Name, "a")
+	checkEq(t, strings.Contains(argoErr.Error(), "cmd sub"), true)
+}
+
+func TestStrictHelp(t *testing.T) {
+	act := Action{Trigger: "cmd", StrictHelp: true}
+	sub := Action{Trigger: "sub"}
+	sub.AddSubAction(Action{Trigger: "help"})
+	act.AddSubAction(sub)
+
+	err := act.Finalize()
+	argoErr, ok := err.(HelpShadowedError)
+	checkEq(t, ok, true)
+	checkEq(t, argoErr.Path, "cmd sub")
+	checkEq(t, argoErr.Trigger, "help")
+
+	act = Action{Trigger: "cmd", StrictHelp: true}
+	act.AddSubAction(Action{Trigger: "sub"})
+	checkEq(t, act.Finalize(), nil)
+}
+
+func TestNotFoundTemplate(t *testing.T) {
+	act := Action{
+		Trigger: "cmd",
+		NotFoundTemplate: func(parentPath, token string) string {
+			return "no " + token + " in " + parentPath
+		},
+	}
+	act.AddSubAction(Action{Trigger: "sub"})
+	checkEq(t, act.Finalize(), nil)
+
+	state := &State{}
+	act.Parse(state, []string{"cmd", "help", "none"})
+	checkEq(t, state.OutputStr.String(), "no none in cmd")
+
+	state = &State{}
+	act.Parse(state, []string{"cmd", "sub", "help", "none"})
+	checkEq(t, state.OutputStr.String(), "no none in cmd sub")
+
+	// The template is used by UnknownTriggerError as well
+	act.RejectUnknownTriggers = true
+	checkEq(t, act.Refinalize(), nil)
+	err := act.Parse(&State{}, []string{"cmd", "none"})
+	checkEq(t, err, UnknownTriggerError{Path: "cmd", Arg: "none", Candidates: []string{"sub", "help"}, Message: "no none in cmd"})
+	checkEq(t, err.Error(), "no none in cmd")
+
+	act = Action{Trigger: "cmd"}
+	checkEq(t, act.Finalize(), nil)
+	state = &State{}
+	act.Parse(state, []string{"cmd", "help", "none"})
+	checkEq(t, state.OutputStr.String(), "Sub action not found: cmd none")
+}
+
+func TestGlobalUniqueTriggers(t *testing.T) {
+	newTree := func(leaf string) Action {
+		root := Action{Trigger: "root", GlobalUniqueTriggers: true}
+		file := Action{Trigger: "file"}
+		file.AddSubAction(Action{Trigger: leaf})
+		root.AddSubAction(file)
+		root.AddSubAction(Action{Trigger: "rm"})
+		return root
+	}
+
+	root := newTree("cp")
+	checkEq(t, root.Finalize(), nil)
+
+	root = newTree("rm")
+	err := root.Finalize()
+	argoErr, ok := err.(GlobalTriggerCollisionError)
+	checkEq(t, ok, true)
+	checkEq(t, argoErr.Trigger, "rm")
+	checkEq(t, argoErr.Paths, []string{"root file rm", "root rm"})
+	checkEq(t, root.finalized, false)
+
+	root.GlobalUniqueTriggers = false
+	checkEq(t, root.Finalize(), nil)
+}
+
+func TestFind(t *testing.T) {
+	root := Action{Trigger: "root"}
+	file := Action{Trigger: "file"}
+	file.AddSubAction(Action{Trigger: "cp", ShortDescr: "copy"})
+	root.AddSubAction(file)
+	checkEq(t, root.Finalize(), nil)
+
+	act, ok := root.Find("file cp")
+	checkEq(t, ok, true)
+	checkEq(t, act.ShortDescr, "copy")
+	checkEq(t, act.Path(), "root file cp")
+
+	act, ok = root.Find(" file  cp ")
+	checkEq(t, ok, true)
+	checkEq(t, act.Path(), "root file cp")
+
+	act, ok = root.Find("file mv cp")
+	checkEq(t, ok, false)
+	checkEq(t, act.Trigger, "")
+
+	act, ok = root.Find("")
+	checkEq(t, ok, true)
+	checkEq(t, act.Trigger, "root")
+}
+
+func TestHelpGenContext(t *testing.T) {
+	root := Action{
+		Trigger: "root",
+		HelpGenContext: func(act Action, state *State) string {
+			if admin, _ := state.Get("admin"); admin == true {
+				return act.Path() + " (admin)"
+			}
+			return act.Path()
+		},
+	}
+	root.AddSubAction(Action{Trigger: "sub"})
+	checkEq(t, root.Finalize(), nil)
+
+	state := State{}
+	checkEq(t, root.Parse(&state, []string{"root", "help", "sub"}), nil)
+	checkEq(t, state.OutputStr.String(), "root sub")
+
+	state = State{}
+	state.Set("admin", true)
+	checkEq(t, root.Parse(&state, []string{"root", "help", "sub"}), nil)
+	checkEq(t, state.OutputStr.String(), "root sub (admin)")
+
+	// Help() still uses the static HelpGen
+	checkNe(t, root.Help(), "root")
+}
+
+func TestErrorClearsOutput(t *testing.T) {
+	root := Action{
+		Trigger: "root",
+		Do: func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString("root;")
+			return nil
+		},
+	}
+	root.AddSubAction(Action{
+		Trigger: "fail",
+		Do: func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString("partial")
+			return CustomError{}
+		},
+	})
+	checkEq(t, root.Finalize(), nil)
+
+	state := State{}
+	checkEq(t, root.Parse(&state, []string{"root", "fail"}), CustomError{})
+	checkEq(t, state.OutputStr.String(), "root;partial")
+
+	root = Action{
+		Trigger:           "root",
+		ErrorClearsOutput: true,
+		OnError: func(state *State, err error) {
+			state.OutputStr.WriteString("failed")
+		},
+		Do: func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString("root;")
+			return nil
+		},
+	}
+	root.AddSubAction(Action{
+		Trigger: "fail",
+		Do: func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString("partial")
+			return CustomError{}
+		},
+	})
+	checkEq(t, root.Finalize(), nil)
+
+	state = State{}
+	state.OutputStr.WriteString("before;")
+	checkEq(t, root.Parse(&state, []string{"root", "fail"}), CustomError{})
+	checkEq(t, state.OutputStr.String(), "before;failed")
+
+	state = State{}
+	checkEq(t, root.Parse(&state, []string{"root"}), nil)
+	checkEq(t, state.OutputStr.String(), "root;")
+}
+
+func TestHelpColor(t *testing.T) {
+	newTree := func(color bool) Action {
+		root := Action{Trigger: "root", ShortDescr: "root action", HelpColor: color}
+		sub := Action{Trigger: "sub", ShortDescr: "sub action"}
+		sub.AddSubAction(Action{Trigger: "leaf"})
+		root.AddSubAction(sub)
+		root.Finalize()
+		return root
+	}
+
+	plain := newTree(false)
+	checkEq(t, strings.Contains(plain.Help(), "\x1b["), false)
+
+	color := newTree(true)
+	help := color.Help()
+	checkEq(t, strings.Contains(help, "\x1b[1m[Usage]\x1b[0m\n"), true)
+	checkEq(t, strings.Contains(help, "\x1b[1m[Description]\x1b[0m\n"), true)
+	checkEq(t, strings.Contains(help, "\x1b[1m[Sub-actions]\x1b[0m"), true)
+	checkEq(t, strings.Contains(help, "\n\x1b[36msub\x1b[0m\n"), true)
+
+	// Removing escape codes gives the plain help text
+	stripped := strings.NewReplacer("\x1b[1m", "", "\x1b[36m", "", "\x1b[0m", "").Replace(help)
+	checkEq(t, stripped, plain.Help())
+
+	sub := color.GetSubActionPtr("sub")
+	checkEq(t, strings.Contains(sub.Help(), "\x1b[36mleaf\x1b[0m"), true)
+}
+
+func TestTerminalHelpWidth(t *testing.T) {
+	newRoot := func(width func() int) Action {
+		root := Action{
+			Trigger:       "root",
+			ShortDescr:    "one two three four five",
+			HelpWidth:     TerminalHelpWidth,
+			TerminalWidth: width,
+			DisableHelp:   true,
+		}
+		root.Finalize()
+		return root
+	}
+
+	root := newRoot(func() int { return 10 })
+	checkEq(t, strings.HasSuffix(root.Help(), "[Description]\none two\nthree four\nfive"), true)
+
+	root = newRoot(func() int { return 0 })
+	checkEq(t, strings.HasSuffix(root.Help(), "[Description]\none two three four five"), true)
+
+	t.Setenv("COLUMNS", "14")
+	root = newRoot(nil)
+	checkEq(t, strings.HasSuffix(root.Help(), "[Description]\none two three\nfour five"), true)
+
+	// The width is queried again for each help text
+	width := 10
+	root = newRoot(func() int { return width })
+	checkEq(t, strings.HasSuffix(root.Help(), "[Description]\none two\nthree four\nfive"), true)
+	width = 14
+	checkEq(t, strings.HasSuffix(root.Help(), "[Description]\none two three\nfour five"), true)
+}
+
+func TestMatchFunc(t *testing.T) {
+	prefix := func(trigger, token string) bool {
+		return strings.HasPrefix(token, trigger)
+	}
+
+	triggered := ""
+	root := Action{Trigger: "bot", MatchFunc: prefix}
+	root.AddSubAction(Action{
+		Trigger: "@list",
+		Do: func(state *State, _ ...interface{}) error {
+			triggered = "list"
+			return nil
+		},
+	})
+	root.AddSubAction(Action{
+		Trigger:   "@",
+		MatchFunc: prefix,
+		Do: func(state *State, _ ...interface{}) error {
+			triggered = "mention"
+			return nil
+		},
+	})
+	checkEq(t, root.Finalize(), nil)
+
+	checkEq(t, root.Parse(&State{}, []string{"bot!", "@alice"}), nil)
+	checkEq(t, triggered, "mention")
+
+	// map based SubAction registered before the MatchFunc SubAction wins
+	checkEq(t, root.Parse(&State{}, []string{"bot", "@list"}), nil)
+	checkEq(t, triggered, "list")
+
+	triggered = ""
+	checkEq(t, root.Parse(&State{}, []string{"bo", "@alice"}), nil)
+	checkEq(t, triggered, "")
+
+	state := State{}
+	checkEq(t, root.Parse(&state, []string{"bot", "help", "@list"}), nil)
+	checkNe(t, state.OutputStr.String(), "")
+}
+
+func TestUsageVariadicName(t *testing.T) {
+	act := Action{Trigger: "cmd"}
+	act.AddSubAction(Action{Trigger: "say", VariadicName: "message", MinConsume: 1, MaxConsume: -1})
+	act.AddSubAction(Action{Trigger: "log", VariadicName: "message", MaxConsume: -1})
+	act.AddSubAction(Action{Trigger: "tell", ArgNames: []string{"user"}, VariadicName: "message", MinConsume: 2, MaxConsume: -1})
+	act.AddSubAction(Action{Trigger: "note", ArgNames: []string{"user", "tag"}, VariadicName: "message", MinConsume: 1, MaxConsume: -1})
+	act.AddSubAction(Action{Trigger: "multi", ArgNames: []string{"user"}, VariadicName: "message", MinConsume: 1, MaxConsume: -1, MultilineUsage: true})
+	checkEq(t, act.Finalize(), nil)
+
+	checkEq(t, act.GetSubAction("say").Usage(), "cmd say <message...>")
+	checkEq(t, act.GetSubAction("log").Usage(), "cmd log [message...]")
+	checkEq(t, act.GetSubAction("tell").Usage(), "cmd tell <user> <message...>")
+	checkEq(t, act.GetSubAction("note").Usage(), "cmd note <user> [tag] [message...]")
+	checkEq(t, act.GetSubAction("multi").Usage(), "cmd multi\n  <user>        (required)\n  [message...]  (optional, repeatable)")
+}
+
+func TestHelpDepth(t *testing.T) {
+	newTree := func(depth int) Action {
+		root := Action{Trigger: "root", HelpDepth: depth}
+		build := Action{Trigger: "build"}
+		build.AddSubAction(Action{Trigger: "clean", ShortDescr: "Clean outputs"})
+		root.AddSubAction(build)
+		root.Finalize()
+		return root
+	}
+
+	root := newTree(0)
+	clean := root.GetSubActionPtr("build").GetSubActionPtr("clean")
+
+	state := State{}
+	checkEq(t, root.Parse(&state, []string{"root", "help", "build", "clean"}), nil)
+	checkEq(t, state.OutputStr.String(), clean.Help())
+
+	state = State{}
+	checkEq(t, root.Parse(&state, []string{"root", "help", "build", "run", "x"}), nil)
+	checkEq(t, state.OutputStr.String(), "Sub action not found: root build run")
+
+	state = State{}
+	checkEq(t, root.Parse(&state, []string{"root", "help", "make", "clean"}), nil)
+	checkEq(t, state.OutputStr.String(), "Sub action not found: root make")
+
+	// Args beyond HelpDepth are not consumed by help
+	root = newTree(1)
+	build := root.GetSubActionPtr("build")
+	state = State{}
+	checkEq(t, root.Parse(&state, []string{"root", "help", "build", "clean"}), nil)
+	checkEq(t, state.OutputStr.String(), build.Help())
+}
+
+func TestTracer(t *testing.T) {
+	events := []TraceEvent{}
+	root := Action{
+		Trigger: "root",
+		Tracer: func(event TraceEvent) {
+			events = append(events, event)
+		},
+	}
+	file := Action{Trigger: "file", MaxConsume: 1}
+	file.AddSubAction(Action{
+		Trigger:    "cp",
+		MinConsume: 2,
+		MaxConsume: 2,
+		Do: func(*State, ...interface{}) error {
+			return CustomError{}
+		},
+	})
+	root.AddSubAction(file)
+	checkEq(t, root.Finalize(), nil)
+
+	err := root.Parse(&State{}, []string{"root", "file", "a", "cp", "x", "y"})
+	checkEq(t, err, CustomError{})
+	checkEq(t, events, []TraceEvent{
+		{Kind: TraceMatched, Path: "root", Args: []string{"root"}},
+		{Kind: TraceConsumed, Path: "root", Args: []string{}},
+		{Kind: TraceDispatched, Path: "root", Args: []string{"file"}, Next: "root file"},
+		{Kind: TraceMatched, Path: "root file", Args: []string{"file"}},
+		{Kind: TraceConsumed, Path: "root file", Args: []string{"a"}},
+		{Kind: TraceDispatched, Path: "root file", Args: []string{"cp"}, Next: "root file cp"},
+		{Kind: TraceMatched, Path: "root file cp", Args: []string{"cp"}},
+		{Kind: TraceConsumed, Path: "root file cp", Args: []string{"x", "y"}},
+		{Kind: TraceError, Path: "root file cp", Err: CustomError{}},
+	})
+}
+
+func TestBacktrackForSubAction(t *testing.T) {
+	newTree := func(backtrack bool) (Action, *[]string) {
+		called := []string{}
+		act := Action{
+			Trigger:               "test",
+			MinConsume:            1,
+			MaxConsume:            3,
+			BacktrackForSubAction: backtrack,
+			Do: func(state *State, _ ...interface{}) error {
+				called = append(called, strings.Join(state.Args(), ","))
+				return nil
+			},
+		}
+		act.AddSubAction(Action{
+			Trigger:    "sub",
+			MaxConsume: -1,
+			Do: func(state *State, _ ...interface{}) error {
+				called = append(called, "sub:"+strings.Join(state.Args(), ","))
+				return nil
+			},
+		})
+		act.Finalize()
+		return act, &called
+	}
+
+	act, called := newTree(false)
+	checkEq(t, act.Parse(&State{}, []string{"test", "a", "sub", "b"}), nil)
+	checkEq(t, *called, []string{"a,sub,b"})
+
+	act, called = newTree(true)
+	checkEq(t, act.Parse(&State{}, []string{"test", "a", "sub", "b"}), nil)
+	checkEq(t, *called, []string{"a", "sub:b"})
+
+	// Required args are always consumed
+	act, called = newTree(true)
+	checkEq(t, act.Parse(&State{}, []string{"test", "sub", "sub", "b"}), nil)
+	checkEq(t, *called, []string{"sub", "sub:b"})
+
+	// Disabled SubActions do not stop consuming
+	act, called = newTree(true)
+	state := &State{DisabledCommands: map[string]bool{"test sub": true}}
+	checkEq(t, act.Parse(state, []string{"test", "a", "sub", "b"}), nil)
+	checkEq(t, *called, []string{"a,sub,b"})
+}
+
+func TestHelpFlag(t *testing.T) {
+	root := Action{Trigger: "cmd"}
+	build := Action{Trigger: "build", MaxConsume: 1}
+	build.AddSubAction(Action{Trigger: "clean", MinConsume: 2, MaxConsume: 2})
+	root.AddSubAction(build)
+	checkEq(t, root.Finalize(), nil)
+	buildPtr := root.GetSubActionPtr("build")
+	cleanPtr := buildPtr.GetSubActionPtr("clean")
+
+	cases := []struct {
+		args []string
+		help string
+	}{
+		{[]string{"cmd", "--help"}, root.Help()},
+		{[]string{"cmd", "-h"}, root.Help()},
+		{[]string{"cmd", "build", "-h"}, buildPtr.Help()},
+		{[]string{"cmd", "build", "x", "--help"}, buildPtr.Help()},
+		{[]string{"cmd", "build", "x", "clean", "--help"}, cleanPtr.Help()},
+		{[]string{"cmd", "build", "x", "clean", "a", "-h"}, cleanPtr.Help()},
+	}
+	for _, c := range cases {
+		state := State{}
+		checkEq(t, root.Parse(&state, c.args), nil)
+		checkEq(t, state.OutputStr.String(), c.help)
+	}
+
+	custom := Action{Trigger: "cmd", HelpFlag: "--usage", HelpShortFlag: "-?", MaxConsume: -1}
+	checkEq(t, custom.Finalize(), nil)
+
+	state := State{}
+	checkEq(t, custom.Parse(&state, []string{"cmd", "a", "--usage"}), nil)
+	checkEq(t, state.OutputStr.String(), custom.Help())
+
+	state = State{}
+	checkEq(t, custom.Parse(&state, []string{"cmd", "-?"}), nil)
+	checkEq(t, state.OutputStr.String(), custom.Help())
+
+	state = State{}
+	checkEq(t, custom.Parse(&state, []string{"cmd", "--help"}), nil)
+	checkEq(t, state.OutputStr.String(), "")
+
+	// Help flags after `--` or ConsumeUntil are args
+	var args []string
+	grep := Action{
+		Trigger:      "grep",
+		MaxConsume:   -1,
+		ConsumeUntil: "+",
+		Do: func(state *State, _ ...interface{}) error {
+			args = state.Args()
+			return nil
+		},
+	}
+	grep.AddSubAction(Action{Trigger: "then", MaxConsume: -1})
+	checkEq(t, grep.Finalize(), nil)
+
+	state = State{}
+	checkEq(t, grep.Parse(&state, []string{"grep", "foo", "--", "-h", "file"}), nil)
+	checkEq(t, state.OutputStr.String(), "")
+	checkEq(t, args, []string{"foo", "--", "-h", "file"})
+
+	state = State{}
+	checkEq(t, grep.Parse(&state, []string{"grep", "foo", "+", "then", "-h"}), nil)
+	checkEq(t, state.OutputStr.String(), grep.GetSubActionPtr("then").Help())
+
+	// Options and their values are not counted as consumed args
+	app := Action{Trigger: "app", Options: []Option{{Name: "out", HasValue: true}, {Name: "verbose", Short: "v"}}}
+	checkEq(t, app.Finalize(), nil)
+
+	for _, helpArgs := range [][]string{{"app", "--out", "x", "--help"}, {"app", "--out=x", "-v", "-h"}} {
+		state = State{}
+		checkEq(t, app.Parse(&state, helpArgs), nil)
+		checkEq(t, state.OutputStr.String(), app.Help())
+	}
+
+	// Options conflicting with help flags are rejected
+	conflict := Action{Trigger: "grep", Options: []Option{{Name: "no-filename", Short: "h"}}}
+	err := conflict.Finalize()
+	checkEq(t, err, HelpFlagConflictError{Path: "grep", Flag: "-h"})
+	checkEq(t, err.Error(), "Option -h conflicts with the help flag of Action: grep")
+
+	conflict = Action{Trigger: "grep", Options: []Option{{Name: "usage"}}, HelpFlag: "--usage"}
+	checkEq(t, conflict.Finalize(), HelpFlagConflictError{Path: "grep", Flag: "--usage"})
+
+	conflict = Action{Trigger: "grep", Options: []Option{{Name: "no-filename", Short: "h"}}, DisableHelp: true}
+	checkEq(t, conflict.Finalize(), nil)
+}
+
+func TestRefinalize(t *testing.T) {
+	root := Action{Trigger: "root"}
+	root.AddSubAction(Action{Trigger: "sub"})
+	checkEq(t, root.Finalized(), false)
+
+	checkEq(t, root.Finalize(), nil)
+	checkEq(t, root.Finalized(), true)
+	checkTypeEq(t, root.Finalize(), DoubleFinalizeError{})
+
+	output := func(str string) func(*State, ...interface{}) error {
+		return func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString(str)
+			return nil
+		}
+	}
+	checkEq(t, root.AddSubAction(Action{Trigger: "new", Do: output("new")}), nil)
+	checkEq(t, root.GetSubActionPtr("sub").AddSubAction(Action{Trigger: "leaf", Do: output("leaf")}), nil)
+
+	checkEq(t, root.Refinalize(), nil)
+	checkEq(t, root.Finalized(), true)
+	checkEq(t, root.SubActions(), []string{"sub", "new", "help"})
+
+	state := State{}
+	checkEq(t, root.Parse(&state, []string{"root", "new"}), nil)
+	checkEq(t, state.OutputStr.String(), "new")
+
+	state = State{}
+	checkEq(t, root.Parse(&state, []string{"root", "sub", "leaf"}), nil)
+	checkEq(t, state.OutputStr.String(), "leaf")
+
+	checkEq(t, strings.Contains(root.Help(), "\nnew\n"), true)
+	checkEq(t, root.GetSubActionPtr("sub").SubActions(), []string{"leaf", "help"})
+}
+
+func TestRefinalizeInherited(t *testing.T) {
+	root := Action{Trigger: "root", HelpWidth: 40, InferConsumeFromArgNames: true}
+	root.AddSubAction(Action{Trigger: "sub", ArgNames: []string{"a", "b"}})
+	root.AddSubAction(Action{Trigger: "opt", ArgNames: []string{"a", "[b]"}})
+	root.AddSubAction(Action{Trigger: "narrow", HelpWidth: 30})
+	checkEq(t, root.Finalize(), nil)
+	checkEq(t, root.GetSubAction("sub").HelpWidth, 40)
+	checkEq(t, root.GetSubAction("sub").MaxConsume, 2)
+
+	// Inherited values follow the parent, and inferred bounds follow ArgNames
+	root.HelpWidth = 60
+	root.GetSubActionPtr("sub").ArgNames = []string{"a", "b", "c"}
+	checkEq(t, root.Refinalize(), nil)
+	checkEq(t, root.GetSubAction("sub").HelpWidth, 60)
+	checkEq(t, root.GetSubAction("sub").MaxConsume, 3)
+	checkEq(t, root.GetSubAction("narrow").HelpWidth, 30)
+	checkEq(t, root.GetSubAction("opt").MinConsume, 1)
+	checkEq(t, root.GetSubAction("opt").MaxConsume, 2)
+
+	// Inherited values changed after Finalize() are kept
+	root.GetSubActionPtr("sub").HelpWidth = 50
+	checkEq(t, root.Refinalize(), nil)
+	checkEq(t, root.GetSubAction("sub").HelpWidth, 50)
+}
+
+func TestDefaultHandler(t *testing.T) {
+	handled := []string{}
+	root := Action{
+		Trigger: "root",
+		DefaultHandler: func(state *State, act *Action, vargs ...interface{}) error {
+			handled = append(handled, act.Path()+":"+strings.Join(state.Args(), ","))
+			return nil
+		},
+	}
+	file := Action{Trigger: "file"}
+	file.AddSubAction(Action{Trigger: "cp", MinConsume: 2, MaxConsume: 2})
+	file.AddSubAction(Action{Trigger: "rm", MaxConsume: 1})
+	file.AddSubAction(Action{
+		Trigger: "ls",
+		Do: func(*State, ...interface{}) error {
+			handled = append(handled, "ls")
+			return nil
+		},
+	})
+	root.AddSubAction(file)
+	checkEq(t, root.Finalize(), nil)
+
+	checkEq(t, root.Parse(&State{}, []string{"root", "file", "cp", "a", "b"}), nil)
+	checkEq(t, root.Parse(&State{}, []string{"root", "file", "rm", "c"}), nil)
+	checkEq(t, root.Parse(&State{}, []string{"root", "file", "ls"}), nil)
+	checkEq(t, root.Parse(&State{}, []string{"root", "file"}), nil)
+	checkEq(t, handled, []string{"root file cp:a,b", "root file rm:c", "ls"})
+}
+
+func TestConsumeExpr(t *testing.T) {
+	cases := []struct {
+		expr       string
+		minConsume int
+		maxConsume int
+	}{
+		{"2", 2, 2},
+		{"2..4", 2, 4},
+		{"2..", 2, -1},
+		{"*", 0, -1},
+		{"0", 0, 0},
+	}
+	for _, c := range cases {
+		act := Action{Trigger: "cmd", Consume: c.expr}
+		checkEq(t, act.Finalize(), nil)
+		checkEq(t, act.MinConsume, c.minConsume)
+		checkEq(t, act.MaxConsume, c.maxConsume)
+		checkEq(t, act.Refinalize(), nil)
+		checkEq(t, act.MaxConsume, c.maxConsume)
+	}
+
+	for _, expr := range []string{"x", "-1", "4..2", "..2", "1..x", "1...2", "+1"} {
+		act := Action{Trigger: "cmd", Consume: expr}
+		checkEq(t, act.Finalize(), BadConsumeExprError{Path: "cmd", Consume: expr})
+	}
+
+	act := Action{Trigger: "cmd", Consume: "2", MaxConsume: 3}
+	checkEq(t, act.Finalize(), ConsumeConflictError{Path: "cmd", Consume: "2"})
+
+	act = Action{Trigger: "cmd", Consume: "*"}
+	act.AddSubAction(Action{Trigger: "sub"})
+	checkTypeEq(t, act.Finalize(), UnreachableActionError{})
+}
+
+func TestConsumeUntil(t *testing.T) {
+	called := []string{}
+	record := func(state *State, _ ...interface{}) error {
+		called = append(called, state.path+":"+strings.Join(state.Args(), ","))
+		return nil
+	}
+
+	root := Action{Trigger: "root"}
+	echo := Action{Trigger: "echo", MaxConsume: -1, ConsumeUntil: "--", Do: record}
+	checkEq(t, echo.AddSubAction(Action{Trigger: "then", MaxConsume: -1, Do: record}), nil)
+	root.AddSubAction(echo)
+	checkEq(t, root.Finalize(), nil)
+
+	checkEq(t, root.Parse(&State{}, []string{"root", "echo", "a", "then", "--", "then", "b"}), nil)
+	checkEq(t, called, []string{"root echo:a,then", "root echo then:b"})
+
+	called = []string{}
+	checkEq(t, root.Parse(&State{}, []string{"root", "echo", "a", "then", "b"}), nil)
+	checkEq(t, called, []string{"root echo:a,then,b"})
+
+	noUntil := Action{Trigger: "echo", MaxConsume: -1}
+	checkTypeEq(t, noUntil.AddSubAction(Action{Trigger: "then"}), UnreachableActionError{})
+}
+
+func TestOnUnmatched(t *testing.T) {
+	var unmatched []string
+	at := ""
+	root := Action{
+		Trigger: "root",
+		OnUnmatched: func(tokens []string, act *Action) {
+			unmatched = tokens
+			at = act.Path()
+		},
+	}
+	root.AddSubAction(Action{Trigger: "sub", MaxConsume: 1})
+	checkEq(t, root.Finalize(), nil)
+
+	checkEq(t, root.Parse(&State{}, []string{"root", "sub", "a", "b", "c"}), nil)
+	checkEq(t, unmatched, []string{"b", "c"})
+	checkEq(t, at, "root sub")
+
+	checkEq(t, root.Parse(&State{}, []string{"root", "x"}), nil)
+	checkEq(t, unmatched, []string{"x"})
+	checkEq(t, at, "root")
+
+	unmatched, at = nil, ""
+	checkEq(t, root.Parse(&State{}, []string{"root", "sub", "a"}), nil)
+	checkEq(t, unmatched, []string(nil))
+	checkEq(t, at, "")
+}
+
+func TestVersion(t *testing.T) {
+	root := Action{Trigger: "cmd", Version: "v1.2.3"}
+	root.AddSubAction(Action{Trigger: "sub"})
+	checkEq(t, root.Finalize(), nil)
+	checkEq(t, root.SubActions(), []string{"sub", "version", "help"})
+
+	state := State{}
+	checkEq(t, root.Parse(&state, []string{"cmd", "version"}), nil)
+	checkEq(t, state.OutputStr.String(), "v1.2.3")
+
+	state = State{}
+	checkEq(t, root.Parse(&state, []string{"cmd", "--version"}), nil)
+	checkEq(t, state.OutputStr.String(), "v1.2.3")
+
+	// SubActions do not have version
+	state = State{}
+	checkEq(t, root.Parse(&state, []string{"cmd", "sub", "version"}), nil)
+	checkEq(t, state.OutputStr.String(), "")
+
+	// The version SubAction has no help, and is not listed as a command
+	state = State{}
+	checkEq(t, root.Parse(&state, []string{"cmd", "version", "help"}), nil)
+	checkEq(t, state.OutputStr.String(), "v1.2.3")
+	checkEq(t, len(root.Index(true)), 1)
+	checkEq(t, strings.Contains(root.HelpTree(), "version"), false)
+
+	// The version SubAction is injected again with the new Version
+	root.Version = "v2.0.0"
+	checkEq(t, root.Refinalize(), nil)
+	checkEq(t, root.SubActions(), []string{"sub", "version", "help"})
+
+	state = State{}
+	checkEq(t, root.Parse(&state, []string{"cmd", "version"}), nil)
+	checkEq(t, state.OutputStr.String(), "v2.0.0")
+
+	custom := Action{Trigger: "cmd", Version: "v1.2.3", VersionTrigger: "ver"}
+	checkEq(t, custom.Finalize(), nil)
+	checkEq(t, custom.SubActions(), []string{"ver", "help"})
+
+	override := Action{Trigger: "cmd", Version: "v1.2.3"}
+	override.AddSubAction(Action{
+		Trigger: "version",
+		Do: func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString("custom")
+			return nil
+		},
+	})
+	checkEq(t, override.Finalize(), nil)
+
+	state = State{}
+	checkEq(t, override.Parse(&state, []string{"cmd", "version"}), nil)
+	checkEq(t, state.OutputStr.String(), "custom")
+
+	state = State{}
+	checkEq(t, override.Parse(&state, []string{"cmd", "--version"}), nil)
+	checkEq(t, state.OutputStr.String(), "v1.2.3")
+}
+
+func TestInferConsumeFromArgNames(t *testing.T) {
+	root := Action{Trigger: "cmd", InferConsumeFromArgNames: true}
+	root.AddSubAction(Action{Trigger: "cp", ArgNames: []string{"src", "dst"}})
+	root.AddSubAction(Action{Trigger: "mv", ArgNames: []string{"src", "dst", "[mode]"}})
+	root.AddSubAction(Action{Trigger: "echo", ArgNames: []string{"first", "rest..."}})
+	root.AddSubAction(Action{Trigger: "log", ArgNames: []string{"[rest...]"}})
+	root.AddSubAction(Action{Trigger: "set", ArgNames: []string{"a"}, MinConsume: 0, MaxConsume: 2})
+	checkEq(t, root.Finalize(), nil)
+
+	cases := []struct {
+		trigger    string
+		minConsume int
+		maxConsume int
+		usage      string
+	}{
+		{"cp", 2, 2, "cmd cp <src> <dst>"},
+		{"mv", 2, 3, "cmd mv <src> <dst> [mode]"},
+		{"echo", 2, -1, "cmd echo <first> <rest...>"},
+		{"log", 0, -1, "cmd log [rest...]"},
+		{"set", 0, 2, "cmd set [a arg2]"},
+	}
+	for _, c := range cases {
+		act := root.GetSubAction(c.trigger)
+		checkEq(t, act.MinConsume, c.minConsume)
+		checkEq(t, act.MaxConsume, c.maxConsume)
+		checkEq(t, act.Usage(), c.usage)
+	}
+
+	state := State{}
+	checkEq(t, root.Parse(&state, []string{"cmd", "mv", "a", "b"}), nil)
+	checkTypeEq(t, root.Parse(&state, []string{"cmd", "cp", "a"}), TooFewArgsError{})
+
+	root = Action{Trigger: "cmd", InferConsumeFromArgNames: true}
+	root.AddSubAction(Action{Trigger: "mv", ArgNames: []string{"src", "[mode]", "dst"}})
+	err := root.Finalize()
+	checkEq(t, err, RequiredAfterOptionalError{Path: "cmd mv", Name: "dst"})
+	checkEq(t, err.Error(), `Required ArgName "dst" follows an optional one in Action: cmd mv`)
+
+	root = Action{Trigger: "cmd", InferConsumeFromArgNames: true}
+	root.AddSubAction(Action{Trigger: "echo", ArgNames: []string{"[first]", "rest..."}})
+	checkEq(t, root.Finalize(), RequiredAfterOptionalError{Path: "cmd echo", Name: "rest..."})
+}
+
+func TestSortSubActions(t *testing.T) {
+	build := func(sorted bool) *Action {
+		root := &Action{Trigger: "cmd", SortSubActions: sorted}
+		for _, trigger := range []string{"push", "add", "commit"} {
+			root.AddSubAction(Action{Trigger: trigger, ShortDescr: trigger + " changes"})
+		}
+		checkEq(t, root.Finalize(), nil)
+		return root
+	}
+
+	insertion := build(false)
+	checkEq(t, strings.HasSuffix(insertion.Help(), `[Sub-actions]
+push
+- push changes
+add
+- add changes
+commit
+- commit changes
+help
+- Display help for commands`), true)
+
+	sorted := build(true)
+	checkEq(t, strings.HasSuffix(sorted.Help(), `[Sub-actions]
+add
+- add changes
+commit
+- commit changes
+push
+- push changes
+help
+- Display help for commands`), true)
+	checkEq(t, sorted.SubActions(), []string{"push", "add", "commit", "help"})
+}
+
+func TestSectionTitles(t *testing.T) {
+	root := Action{
+		Trigger:          "cmd",
+		ShortDescr:       "the program",
+		UsageTitle:       "Utilisation:",
+		DescriptionTitle: "Description:",
+		SubActionsTitle:  "Commandes:",
+		DisableHelp:      true,
+	}
+	sub := Action{
+		Trigger:      "sub",
+		Options:      []Option{{Name: "force", Descr: "forcer"}},
+		OptionsTitle: "Options:",
+		DisableHelp:  true,
+	}
+	root.AddSubAction(sub)
+	checkEq(t, root.Finalize(), nil)
+
+	checkEq(t, root.Help(), `Utilisation:
+cmd [sub-action]
+
+Description:
+the program
+
+Commandes:
+sub
+- `)
+
+	subPtr := root.GetSubActionPtr("sub")
+	checkEq(t, subPtr.Help(), `Utilisation:
+cmd sub [options] [sub-action]
+
+Options:
+--[no-]force
+- forcer`)
+
+	plain := Action{Trigger: "cmd", Options: []Option{{Name: "force"}}, DisableHelp: true}
+	checkEq(t, plain.Finalize(), nil)
+	checkEq(t, plain.Help(), "[Usage]\ncmd [options] [sub-action]\n\n[Options]\n--[no-]force\n- ")
+}
+
+func TestPromptFunc(t *testing.T) {
+	var args []string
+	prompted := []string{}
+	root := Action{
+		Trigger: "root",
+		PromptFunc: func(argName string) (string, error) {
+			prompted = append(prompted, argName)
+			if argName == "fail" {
+				return "", CustomError{}
+			}
+			return "<" + argName + ">", nil
+		},
+	}
+	var consumedCount, suppliedCount int
+	record := func(state *State, _ ...interface{}) error {
+		args = state.Args()
+		consumedCount, suppliedCount = state.ConsumedCount(), state.SuppliedCount()
+		return nil
+	}
+	root.AddSubAction(Action{Trigger: "cp", MinConsume: 3, MaxConsume: 3, ArgNames: []string{"src", "dst"}, Do: record})
+	root.AddSubAction(Action{Trigger: "bad", MinConsume: 1, ArgNames: []string{"fail"}, Do: record})
+	checkEq(t, root.Finalize(), nil)
+
+	checkEq(t, root.Parse(&State{}, []string{"root", "cp", "a"}), nil)
+	checkEq(t, prompted, []string{"dst", "arg3"})
+	checkEq(t, args, []string{"a", "<dst>", "<arg3>"})
+	checkEq(t, consumedCount, 1)
+	checkEq(t, suppliedCount, 3)
+
+	prompted = []string{}
+	checkEq(t, root.Parse(&State{}, []string{"root", "cp", "a", "b", "c"}), nil)
+	checkEq(t, prompted, []string{})
+
+	args = nil
+	checkEq(t, root.Parse(&State{}, []string{"root", "bad"}), CustomError{})
+	checkEq(t, args, []string(nil))
+
+	noPrompt := Action{Trigger: "cp", MinConsume: 1}
+	checkEq(t, noPrompt.Finalize(), nil)
+	checkTypeEq(t, noPrompt.Parse(&State{}, []string{"cp"}), TooFewArgsError{})
+}
+
+func TestPreprocess(t *testing.T) {
+	called := 0
+	output := ""
+	root := Action{
+		Trigger: "git",
+		Preprocess: func(args []string) ([]string, error) {
+			called++
+			if len(args) > 1 && args[1] == "bad" {
+				return nil, CustomError{}
+			}
+
+			ret := []string{}
+			for _, arg := range args {
+				if arg == "co" {
+					arg = "checkout"
+				}
+				ret = append(ret, strings.ToLower(arg))
+			}
+			return ret, nil
+		},
+	}
+	checkout := Action{Trigger: "checkout", MaxConsume: 1}
+	checkout.AddSubAction(Action{
+		Trigger:    "branch",
+		MaxConsume: 1,
+		Do: func(state *State, _ ...interface{}) error {
+			output = state.Args()[0]
+			return nil
+		},
+	})
+	root.AddSubAction(checkout)
+	checkEq(t, root.Finalize(), nil)
+
+	checkEq(t, root.Parse(&State{}, []string{"GIT", "co", "x", "branch", "Main"}), nil)
+	checkEq(t, output, "main")
+	checkEq(t, called, 1)
+
+	output = ""
+	checkEq(t, root.Parse(&State{}, []string{"git", "bad", "x"}), CustomError{})
+	checkEq(t, output, "")
+	checkEq(t, called, 2)
+}
+
+func TestTransactionalOutput(t *testing.T) {
+	output := func(str string) func(*State, ...interface{}) error {
+		return func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString(str)
+			return nil
+		}
+	}
+	newTree := func(root bool, sub bool) Action {
+		act := Action{Trigger: "root", Do: output("root;"), TransactionalOutput: root}
+		mid := Action{Trigger: "mid", Do: output("mid;"), TransactionalOutput: sub}
+		mid.AddSubAction(Action{Trigger: "ok", Do: output("ok")})
+		mid.AddSubAction(Action{
+			Trigger: "fail",
+			Do: func(state *State, _ ...interface{}) error {
+				state.OutputStr.WriteString("partial")
+				return CustomError{}
+			},
+		})
+		act.AddSubAction(mid)
+		act.Finalize()
+		return act
+	}
+
+	cases := []struct {
+		root, sub bool
+		args      []string
+		output    string
+	}{
+		{false, false, []string{"root", "mid", "ok"}, "root;mid;ok"},
+		{true, false, []string{"root", "mid", "ok"}, "root;mid;ok"},
+		{false, false, []string{"root", "mid", "fail"}, "root;mid;partial"},
+		{true, false, []string{"root", "mid", "fail"}, ""},
+		{false, true, []string{"root", "mid", "fail"}, "root;"},
+	}
+	for _, c := range cases {
+		act := newTree(c.root, c.sub)
+		state := State{}
+		act.Parse(&state, c.args)
+		checkEq(t, state.OutputStr.String(), c.output)
+	}
+}
+
+func TestTriggerPhrase(t *testing.T) {
+	deployed := []string{}
+	root := Action{
+		Trigger: "hey bot please",
+	}
+	checkEq(t, root.AddSubAction(Action{
+		Trigger:    "deploy",
+		MaxConsume: 1,
+		Do: func(state *State, _ ...interface{}) error {
+			deployed = append(deployed, state.Args()...)
+			return nil
+		},
+	}), nil)
+	checkEq(t, root.Finalize(), nil)
+
+	checkEq(t, root.Parse(&State{}, []string{"Hey", "BOT", "please", "deploy", "prod"}), nil)
+	checkEq(t, root.Parse(&State{}, []string{"hey bot", " please ", "deploy", "staging"}), nil)
+	checkEq(t, deployed, []string{"prod", "staging"})
+
+	state := &State{}
+	checkEq(t, root.Parse(state, []string{"hey", "bot", "deploy", "dev"}), nil)
+	checkEq(t, root.Parse(state, []string{"hey", "bot", "please!", "deploy", "dev"}), nil)
+	checkEq(t, deployed, []string{"prod", "staging"})
+
+	checkEq(t, root.Parse(&State{}, []string{"hey", "bot", "please", "deploy", "qa"}), nil)
+	checkEq(t, deployed, []string{"prod", "staging", "qa"})
+}
+
+func TestMaxDepth(t *testing.T) {
+	// build returns a tree with levels levels, named "l1 l2 ..."
+	build := func(levels int) *Action {
+		var act *Action
+		for level := levels; level > 0; level-- {
+			parent := &Action{Trigger: fmt.Sprintf("l%d", level)}
+			if act != nil {
+				parent.AddSubAction(*act)
+			}
+			act = parent
+		}
+		return act
+	}
+
+	root := build(4)
+	root.MaxDepth = 4
+	checkEq(t, root.Finalize(), nil)
+	checkEq(t, root.Parse(&State{}, []string{"l1", "l2", "l3", "l4", "help"}), nil)
+
+	root = build(5)
+	root.MaxDepth = 4
+	checkEq(t, root.Finalize(), MaxDepthExceededError{Path: "l1 l2 l3 l4 l5", MaxDepth: 4})
+
+	root = build(defaultMaxDepth)
+	checkEq(t, root.Finalize(), nil)
+
+	root = build(defaultMaxDepth + 1)
+	checkTypeEq(t, root.Finalize(), MaxDepthExceededError{})
+}
+
+func TestAddSubActions(t *testing.T) {
+	root := Action{Trigger: "root"}
+	checkEq(t, root.AddSubActions(Action{Trigger: "a"}, Action{Trigger: "b"}), nil)
+
+	err := root.AddSubActions(
+		Action{Trigger: "c"},
+		Action{Trigger: "a"},
+		Action{},
+		Action{Trigger: "d"},
+	)
+	checkEq(t, err, MultiError{Failures: []SubActionFailure{
+		{Index: 1, Cause: DuplicatedSubActionError{Trigger: "a"}},
+		{Index: 2, Cause: EmptyTriggerError{}},
+	}})
+	checkEq(t, err.Error(), "2 SubActions failed to be added\n"+
+		"[1] SubAction Already Exists, Trigger: a\n"+
+		"[2] Action with empty Trigger is not allowed. Path: ")
+	checkEq(t, root.SubActions(), []string{"a", "b", "c", "d"})
+	checkEq(t, root.Finalize(), nil)
+}
+
+func TestPathSeparator(t *testing.T) {
+	migrated := []string{}
+	root := Action{Trigger: "app", PathSeparator: ":"}
+	db := Action{Trigger: "db"}
+	db.AddSubAction(Action{
+		Trigger:    "migrate",
+		MaxConsume: 1,
+		ArgNames:   []string{"version"},
+		Do: func(state *State, _ ...interface{}) error {
+			migrated = append(migrated, state.Args()...)
+			return nil
+		},
+	})
+	root.AddSubAction(db)
+	checkEq(t, root.Finalize(), nil)
+
+	migrate := root.GetSubAction("db").GetSubAction("migrate")
+	checkEq(t, migrate.Path(), "app:db:migrate")
+	checkEq(t, migrate.Usage(), "app:db:migrate [version]")
+	checkEq(t, strings.HasPrefix(migrate.Help(), "[Usage]\napp:db:migrate [version]"), true)
+
+	checkEq(t, root.Parse(&State{}, []string{"app", "db:migrate", "v1"}), nil)
+	checkEq(t, root.Parse(&State{}, []string{"app", "db", "migrate", "v2"}), nil)
+	checkEq(t, root.Parse(&State{}, []string{"app", "db:unknown", "v3"}), nil)
+	checkEq(t, migrated, []string{"v1", "v2"})
+
+	// Joined args are split for help as well
+	state := State{}
+	checkEq(t, root.Parse(&state, []string{"app", "help", "db:migrate"}), nil)
+	checkEq(t, state.OutputStr.String(), migrate.Help())
+
+	state = State{}
+	checkEq(t, root.Parse(&state, []string{"app", "help", "db:unknown"}), nil)
+	checkEq(t, state.OutputStr.String(), "Sub action not found: app:db:unknown")
+
+	checkEq(t, strings.Contains(root.Grammar(), "app_db_migrate ::= \"migrate\" [version] ;"), true)
+
+	// A joined arg with an empty remaining part is not split
+	captured := []string{}
+	capRoot := Action{Trigger: "app", PathSeparator: ":"}
+	capDb := Action{Trigger: "db"}
+	capDb.AddSubAction(Action{
+		Capture:  true,
+		ArgNames: []string{"cap"},
+		Do: func(state *State, _ ...interface{}) error {
+			value, _ := state.Capture("cap")
+			captured = append(captured, value)
+			return nil
+		},
+	})
+	capRoot.AddSubAction(capDb)
+	checkEq(t, capRoot.Finalize(), nil)
+
+	checkEq(t, capRoot.Parse(&State{}, []string{"app", "db:x"}), nil)
+	checkEq(t, capRoot.Parse(&State{}, []string{"app", "db:"}), nil)
+	checkEq(t, captured, []string{"x"})
+}
+
+func TestRecoverPanics(t *testing.T) {
+	root := Action{Trigger: "root"}
+	root.AddSubAction(Action{
+		Trigger: "crash",
+		Do: func(*State, ...interface{}) error {
+			panic("boom")
+		},
+	})
+	checkEq(t, root.Finalize(), nil)
+
+	func() {
+		defer func() {
+			checkEq(t, recover(), "boom")
+		}()
+		root.Parse(&State{}, []string{"root", "crash"})
+		t.Error("panic is not propagated")
+	}()
+
+	root = Action{Trigger: "root", RecoverPanics: true}
+	root.AddSubAction(Action{
+		Trigger: "crash",
+		Do: func(*State, ...interface{}) error {
+			panic("boom")
+		},
+	})
+	checkEq(t, root.Finalize(), nil)
+
+	err := root.Parse(&State{}, []string{"root", "crash"})
+	checkEq(t, err, ActionPanicError{Path: "root crash", Value: "boom"})
+	checkEq(t, err.Error(), "Action panicked: boom\nActionPath: root crash")
+}
+
+func TestParseAny(t *testing.T) {
+	triggered := ""
+	roots := []Action{}
+	for _, trigger := range []string{"git", "go", "make"} {
+		trigger := trigger
+		root := Action{
+			Trigger:    trigger,
+			MaxConsume: 1,
+			Do: func(state *State, _ ...interface{}) error {
+				triggered = trigger + " " + strings.Join(state.Args(), " ")
+				return nil
+			},
+		}
+		checkEq(t, root.Finalize(), nil)
+		roots = append(roots, root)
+	}
+
+	checkEq(t, ParseAny(&State{}, []string{"go", "build"}, roots), nil)
+	checkEq(t, triggered, "go build")
+
+	err := ParseAny(&State{}, []string{"npm", "install"}, roots)
+	checkEq(t, err, NoMatchingRootError{Arg: "npm"})
+	checkEq(t, err.Error(), `Parsing Error: No Action is triggered by "npm"`)
+	checkEq(t, ParseAny(&State{}, []string{}, roots), NoMatchingRootError{})
+
+	checkTypeEq(t, ParseAny(&State{}, []string{"go"}, []Action{{Trigger: "go"}}), ActionNotFinalizedError{})
+}
+
+func TestDisplayWidth(t *testing.T) {
+	checkEq(t, displayWidth("help"), 4)
+	checkEq(t, displayWidth("說明"), 4)
+	checkEq(t, displayWidth("ｈｅｌｐ"), 8)
+	checkEq(t, displayWidth("café"), 4)
+
+	act := Action{
+		Trigger:        "copy",
+		MinConsume:     1,
+		MaxConsume:     2,
+		ArgNames:       []string{"來源", "dst"},
+		MultilineUsage: true,
+	}
+	checkEq(t, act.Finalize(), nil)
+	checkEq(t, act.Usage(), "copy\n  <來源>  (required)\n  [dst]   (optional)")
+}
+
+func TestHelpFor(t *testing.T) {
+	root := Action{Trigger: "root", ShortDescr: "root command"}
+	remote := Action{Trigger: "remote"}
+	remote.AddSubAction(Action{Trigger: "add", ShortDescr: "add a remote", MinConsume: 1})
+	root.AddSubAction(remote)
+
+	_, err := root.HelpFor("")
+	checkTypeEq(t, err, ActionNotFinalizedError{})
+	checkEq(t, root.Finalize(), nil)
+
+	help, err := root.HelpFor("remote add")
+	checkEq(t, err, nil)
+	add := root.GetSubAction("remote").GetSubAction("add")
+	checkEq(t, help, add.Help())
+	checkEq(t, strings.HasPrefix(help, "[Usage]\nroot remote add <arg1>"), true)
+
+	help, err = root.HelpFor("")
+	checkEq(t, err, nil)
+	checkEq(t, help, root.Help())
+
+	_, err = root.HelpFor("remote rename origin")
+	checkEq(t, err, PathNotFoundError{Path: "root", SubPath: "remote rename origin"})
+	checkEq(t, err.Error(), "Action \"remote rename origin\" is not found\nActionPath: root")
+
+	// Paths follow PathSeparator, and Path() of the target can be used
+	db := Action{Trigger: "db", PathSeparator: ":"}
+	migrate := Action{Trigger: "migrate"}
+	migrate.AddSubAction(Action{Trigger: "up", ShortDescr: "apply migrations"})
+	db.AddSubAction(migrate)
+	checkEq(t, db.Finalize(), nil)
+	up := db.GetSubAction("migrate").GetSubAction("up")
+
+	help, err = db.HelpFor("migrate:up")
+	checkEq(t, err, nil)
+	checkEq(t, help, up.Help())
+
+	help, err = db.HelpFor(up.Path())
+	checkEq(t, err, nil)
+	checkEq(t, help, up.Help())
+
+	help, err = db.HelpFor(db.Path())
+	checkEq(t, err, nil)
+	checkEq(t, help, db.Help())
+
+	_, err = db.HelpFor("migrate up")
+	checkTypeEq(t, err, PathNotFoundError{})
+}
+
+func TestRequireSubAction(t *testing.T) {
+	pulled := false
+	root := Action{Trigger: "git"}
+	remote := Action{Trigger: "remote", RequireSubAction: true}
+	remote.AddSubAction(Action{Trigger: "add", MinConsume: 1})
+	remote.AddSubAction(Action{
+		Trigger: "pull",
+		Do: func(*State, ...interface{}) error {
+			pulled = true
+			return nil
+		},
+	})
+	remote.AddSubAction(Action{Trigger: "debug", Hidden: true})
+	root.AddSubAction(remote)
+	checkEq(t, root.Finalize(), nil)
+
+	err := root.Parse(&State{}, []string{"git", "remote"})
+	checkEq(t, err, SubActionRequiredError{Path: "git remote", Choices: []string{"add", "pull"}})
+	checkEq(t, err.Error(), "Parsing Error: A SubAction is required, choices: add, pull\nActionPath: git remote")
+	checkTypeEq(t, root.Parse(&State{}, []string{"git", "remote", "rename"}), SubActionRequiredError{})
+
+	checkEq(t, root.Parse(&State{}, []string{"git", "remote", "pull"}), nil)
+	checkEq(t, pulled, true)
+
+	// Asking for help is not an error
+	state := &State{}
+	checkEq(t, root.Parse(state, []string{"git", "remote", "help"}), nil)
+	checkEq(t, strings.HasPrefix(state.OutputStr.String(), "[Usage]\ngit remote"), true)
+
+	// Not applied to the parent
+	checkEq(t, root.Parse(&State{}, []string{"git"}), nil)
+
+	// Do() is not called when no SubAction is chosen
+	called := false
+	stash := Action{Trigger: "stash", RequireSubAction: true, Do: func(*State, ...interface{}) error {
+		called = true
+		return nil
+	}}
+	stash.AddSubAction(Action{Trigger: "pop"})
+	checkEq(t, root.AddSubAction(stash), nil)
+	checkEq(t, root.Refinalize(), nil)
+	checkTypeEq(t, root.Parse(&State{}, []string{"git", "stash"}), SubActionRequiredError{})
+	checkEq(t, called, false)
+	checkEq(t, root.Parse(&State{}, []string{"git", "stash", "pop"}), nil)
+	checkEq(t, called, true)
+}
+
+func TestDeprecated(t *testing.T) {
+	root := Action{Trigger: "app", DisableHelp: true}
+	root.AddSubAction(Action{
+		Trigger:    "rm",
+		ShortDescr: "remove files",
+		Deprecated: `use "remove"`,
+		Do: func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString("removed")
+			return nil
+		},
+	})
+	root.AddSubAction(Action{Trigger: "remove", ShortDescr: "remove files"})
+	checkEq(t, root.Finalize(), nil)
+
+	state := &State{}
+	checkEq(t, root.Parse(state, []string{"app", "rm"}), nil)
+	checkEq(t, state.OutputStr.String(), "Warning: \"rm\" is deprecated: use \"remove\"\nremoved")
+
+	state = &State{}
+	checkEq(t, root.Parse(state, []string{"app", "remove"}), nil)
+	checkEq(t, state.OutputStr.String(), "")
+
+	checkEq(t, root.Help(), `[Usage]
+app [sub-action]
+
+[Sub-actions]
+rm (deprecated)
+- remove files
+remove
+- remove files`)
+
+	// The warning is chained as a part of the output of the deprecated Action
+	output := func(str string) func(*State, ...interface{}) error {
+		return func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString(str)
+			return nil
+		}
+	}
+	chain := Action{Trigger: "app", DisableHelp: true, ChainSeparator: "|", Do: output("root\n")}
+	chain.AddSubAction(Action{Trigger: "s", Deprecated: "x", Do: output("sub")})
+	chain.Default = &Action{Deprecated: "y", MaxConsume: -1, Do: output("default")}
+	checkEq(t, chain.Finalize(), nil)
+
+	state = &State{}
+	checkEq(t, chain.Parse(state, []string{"app", "s"}), nil)
+	checkEq(t, state.OutputStr.String(), "root\n|Warning: \"s\" is deprecated: x\nsub")
+
+	// Default without Trigger is named by its Path()
+	state = &State{}
+	checkEq(t, chain.Parse(state, []string{"app", "other"}), nil)
+	checkEq(t, state.OutputStr.String(), "root\n|Warning: \"app\" is deprecated: y\ndefault")
+}
+
+func TestUnknownTriggerError(t *testing.T) {
+	root := Action{Trigger: "git", RejectUnknownTriggers: true}
+	remote := Action{Trigger: "remote"}
+	remote.AddSubAction(Action{Trigger: "add", MinConsume: 2})
+	remote.AddSubAction(Action{Trigger: "remove", MinConsume: 1})
+	remote.AddSubAction(Action{Trigger: "prune", Hidden: true})
+	root.AddSubAction(remote)
+	root.AddSubAction(Action{Trigger: "log", MaxConsume: 1})
+	checkEq(t, root.Finalize(), nil)
+
+	err := root.Parse(&State{}, []string{"git", "remote", "rename", "origin"})
+	checkEq(t, err, UnknownTriggerError{Path: "git remote", Arg: "rename", Candidates: []string{"add", "remove", "help"}})
+	checkEq(t, err.Error(), "Parsing Error: Unknown argument \"rename\", candidates: add, remove, help\nActionPath: git remote")
+
+	err = root.Parse(&State{DisabledCommands: map[string]bool{"git log": true}}, []string{"git", "log"})
+	checkEq(t, err, UnknownTriggerError{Path: "git", Arg: "log", Candidates: []string{"remote", "help"}})
+
+	// Extra args of Actions without SubActions are not unknown triggers
+	checkEq(t, root.Parse(&State{}, []string{"git", "log", "HEAD", "extra"}), nil)
+	checkEq(t, root.Parse(&State{}, []string{"git", "remote", "prune"}), nil)
+
+	// Do() is not called when the next arg is rejected
+	called := false
+	stash := Action{Trigger: "stash", Do: func(*State, ...interface{}) error {
+		called = true
+		return nil
+	}}
+	stash.AddSubAction(Action{Trigger: "pop"})
+	checkEq(t, root.AddSubAction(stash), nil)
+	checkEq(t, root.Refinalize(), nil)
+	checkTypeEq(t, root.Parse(&State{}, []string{"git", "stash", "drop"}), UnknownTriggerError{})
+	checkEq(t, called, false)
+	checkEq(t, root.Parse(&State{}, []string{"git", "stash"}), nil)
+	checkEq(t, called, true)
+}
+
+func TestCandidatesUsageStats(t *testing.T) {
+	stats := map[string]int{"git commit": 3, "git config": 10, "git clone": 5}
+	root := Action{
+		Trigger:               "git",
+		AllowAbbrev:           true,
+		RejectUnknownTriggers: true,
+		UsageStats: func(path string) int {
+			return stats[path]
+		},
+	}
+	root.AddSubAction(Action{Trigger: "commit"})
+	root.AddSubAction(Action{Trigger: "config"})
+	root.AddSubAction(Action{Trigger: "clone"})
+	checkEq(t, root.Finalize(), nil)
+
+	err := root.Parse(&State{}, []string{"git", "co"})
+	checkEq(t, err, AmbiguousTriggerError{Path: "git", Arg: "co", Candidates: []string{"config", "commit"}})
+
+	err = root.Parse(&State{}, []string{"git", "push"})
+	checkEq(t, err.(UnknownTriggerError).Candidates, []string{"config", "clone", "commit", "help"})
+}
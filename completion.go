@@ -0,0 +1,154 @@
+package argo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// completionSubActions returns visible SubActions of act as completion candidates
+// If UsageStats is set, candidates are ordered by usage, most used first
+// Otherwise, or for candidates used equally, candidates are in registration order
+func completionSubActions(act Action) []Action {
+	visible := []Action{}
+	for _, trigger := range act.SubActions() {
+		sub := act.GetSubAction(trigger)
+		if !sub.Hidden {
+			visible = append(visible, sub)
+		}
+	}
+
+	if act.UsageStats != nil {
+		usage := make(map[string]int)
+		for _, sub := range visible {
+			usage[sub.Trigger] = act.UsageStats(sub.Path())
+		}
+		sort.SliceStable(visible, func(i, j int) bool {
+			return usage[visible[i].Trigger] > usage[visible[j].Trigger]
+		})
+	}
+	return visible
+}
+
+// completionDescr converts descr into a single line, as completion descriptions cannot span lines
+func completionDescr(descr string) string {
+	return strings.Join(strings.Fields(descr), " ")
+}
+
+// fishQuote quotes str as a single-quoted fish string
+func fishQuote(str string) string {
+	str = strings.ReplaceAll(str, `\`, `\\`)
+	str = strings.ReplaceAll(str, `'`, `\'`)
+	return "'" + str + "'"
+}
+
+// GenFishCompletion generates a fish shell completion script for `programName` using this Action as root
+// SubActions are completed only after their parent Triggers are typed and none of their siblings is typed,
+// with conditions by __fish_seen_subcommand_from. Hidden SubActions are skipped
+// Candidates are ordered by UsageStats if it is set
+// ShortDescr of SubActions are used as completion descriptions
+func (act Action) GenFishCompletion(programName string) (string, error) {
+	if !act.finalized {
+		return "", ActionNotFinalizedError{Victim: act}
+	}
+
+	text := strings.Builder{}
+	fmt.Fprintf(&text, "complete -c %s -f\n", fishQuote(programName))
+
+	var genAction func(act Action, path []string)
+	genAction = func(act Action, path []string) {
+		visible := completionSubActions(act)
+		if len(visible) == 0 {
+			return
+		}
+
+		// Each Trigger in path has been typed, and none of the SubActions of act
+		conditions := []string{}
+		for _, trigger := range path {
+			conditions = append(conditions, "__fish_seen_subcommand_from "+fishQuote(trigger))
+		}
+
+		triggers := []string{}
+		for _, sub := range visible {
+			triggers = append(triggers, fishQuote(sub.Trigger))
+		}
+		conditions = append(conditions, "not __fish_seen_subcommand_from "+strings.Join(triggers, " "))
+
+		condition := fishQuote(strings.Join(conditions, "; and "))
+		for _, sub := range visible {
+			fmt.Fprintf(&text, "complete -c %s -n %s -a %s -d %s\n",
+				fishQuote(programName), condition, fishQuote(sub.Trigger), fishQuote(completionDescr(sub.ShortDescr)))
+		}
+
+		for _, sub := range visible {
+			genAction(sub, append(path[:len(path):len(path)], sub.Trigger))
+		}
+	}
+	genAction(act, []string{})
+
+	return text.String(), nil
+}
+
+// psQuote quotes str as a single-quoted PowerShell string
+// PowerShell also treats typographic single quotes as quote characters, so they are doubled as well
+func psQuote(str string) string {
+	return "'" + strings.NewReplacer(
+		"'", "''",
+		"\u2018", "\u2018\u2018",
+		"\u2019", "\u2019\u2019",
+		"\u201a", "\u201a\u201a",
+		"\u201b", "\u201b\u201b",
+	).Replace(str) + "'"
+}
+
+// GenPowerShellCompletion generates a PowerShell completion script for `programName` using this Action as root
+// SubActions are completed according to the words already typed, Hidden SubActions are skipped
+// Candidates are ordered by UsageStats if it is set
+// ShortDescr of SubActions are used as completion tooltips
+func (act Action) GenPowerShellCompletion(programName string) (string, error) {
+	if !act.finalized {
+		return "", ActionNotFinalizedError{Victim: act}
+	}
+
+	text := strings.Builder{}
+	fmt.Fprintf(&text, "Register-ArgumentCompleter -Native -CommandName %s -ScriptBlock {\n", psQuote(programName))
+	text.WriteString("    param($wordToComplete, $commandAst, $cursorPosition)\n")
+	text.WriteString("    $completions = @{\n")
+
+	var genAction func(act Action, path []string)
+	genAction = func(act Action, path []string) {
+		visible := completionSubActions(act)
+		if len(visible) == 0 {
+			return
+		}
+
+		fmt.Fprintf(&text, "        %s = @(\n", psQuote(strings.Join(path, " ")))
+		for _, sub := range visible {
+			tooltip := completionDescr(sub.ShortDescr)
+			if tooltip == "" {
+				tooltip = sub.Trigger
+			}
+			fmt.Fprintf(&text, "            ,@(%s, %s)\n", psQuote(sub.Trigger), psQuote(tooltip))
+		}
+		text.WriteString("        )\n")
+
+		for _, sub := range visible {
+			genAction(sub, append(path[:len(path):len(path)], sub.Trigger))
+		}
+	}
+	genAction(act, []string{})
+
+	text.WriteString("    }\n")
+	text.WriteString("    $words = @($commandAst.CommandElements | Select-Object -Skip 1 | ForEach-Object { $_.ToString() })\n")
+	text.WriteString("    if ($wordToComplete -ne '') {\n")
+	text.WriteString("        $words = @($words | Select-Object -First ($words.Count - 1))\n")
+	text.WriteString("    }\n")
+	text.WriteString("    foreach ($item in $completions[($words -join ' ')]) {\n")
+	text.WriteString("        if ($item[0] -like \"$wordToComplete*\") {\n")
+	text.WriteString("            [System.Management.Automation.CompletionResult]::new($item[0], $item[0], 'ParameterValue', $item[1])\n")
+	text.WriteString("        }\n")
+	text.WriteString("    }\n")
+	text.WriteString("}\n")
+
+	return text.String(), nil
+}
@@ -0,0 +1,105 @@
+package argo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenFishCompletion(t *testing.T) {
+	root := Action{Trigger: "root"}
+	sub := Action{Trigger: "sub", ShortDescr: "sub descr"}
+	sub.AddSubAction(Action{Trigger: "leaf", ShortDescr: "leaf descr"})
+	root.AddSubAction(sub)
+	root.AddSubAction(Action{Trigger: "secret", ShortDescr: "hidden", Hidden: true})
+
+	_, err := root.GenFishCompletion("prog")
+	checkTypeEq(t, err, ActionNotFinalizedError{})
+
+	root.Finalize()
+	script, err := root.GenFishCompletion("prog")
+	checkEq(t, err, nil)
+
+	rootCond := `'not __fish_seen_subcommand_from \'sub\' \'help\''`
+	checkEq(t, strings.Contains(script,
+		`complete -c 'prog' -n `+rootCond+` -a 'sub' -d 'sub descr'`), true)
+	checkEq(t, strings.Contains(script,
+		`complete -c 'prog' -n `+rootCond+` -a 'help' -d 'Display help for commands'`), true)
+
+	subCond := `'__fish_seen_subcommand_from \'sub\'; and not __fish_seen_subcommand_from \'leaf\' \'help\''`
+	checkEq(t, strings.Contains(script,
+		`complete -c 'prog' -n `+subCond+` -a 'leaf' -d 'leaf descr'`), true)
+	checkEq(t, strings.Contains(script,
+		`complete -c 'prog' -n `+subCond+` -a 'help'`), true)
+	checkEq(t, strings.Contains(script, "secret"), false)
+}
+
+func TestGenPowerShellCompletion(t *testing.T) {
+	root := Action{Trigger: "root"}
+	sub := Action{Trigger: "sub", ShortDescr: "sub's descr"}
+	sub.AddSubAction(Action{Trigger: "leaf"})
+	root.AddSubAction(sub)
+	root.AddSubAction(Action{Trigger: "secret", Hidden: true})
+
+	_, err := root.GenPowerShellCompletion("prog")
+	checkTypeEq(t, err, ActionNotFinalizedError{})
+
+	root.Finalize()
+	script, err := root.GenPowerShellCompletion("prog")
+	checkEq(t, err, nil)
+
+	checkEq(t, strings.Contains(script, "Register-ArgumentCompleter -Native -CommandName 'prog'"), true)
+	checkEq(t, strings.Contains(script, "        '' = @(\n            ,@('sub', 'sub''s descr')\n"), true)
+	checkEq(t, strings.Contains(script, "            ,@('help', 'Display help for commands')\n"), true)
+	checkEq(t, strings.Contains(script, "        'sub' = @(\n            ,@('leaf', 'leaf')\n"), true)
+	checkEq(t, strings.Contains(script, "secret"), false)
+}
+
+func TestCompletionDescrEscape(t *testing.T) {
+	root := Action{Trigger: "root"}
+	root.AddSubAction(Action{Trigger: "rm", ShortDescr: "remove 'file' at C:\\path\nand $HOME; done"})
+	root.AddSubAction(Action{Trigger: "cp", ShortDescr: "copy \u2018file\u2019"})
+	root.Finalize()
+
+	script, err := root.GenFishCompletion("prog")
+	checkEq(t, err, nil)
+	checkEq(t, strings.Contains(script,
+		`-a 'rm' -d 'remove \'file\' at C:\\path and $HOME; done'`+"\n"), true)
+
+	script, err = root.GenPowerShellCompletion("prog")
+	checkEq(t, err, nil)
+	checkEq(t, strings.Contains(script,
+		`,@('rm', 'remove ''file'' at C:\path and $HOME; done')`+"\n"), true)
+	checkEq(t, strings.Contains(script,
+		",@('cp', 'copy \u2018\u2018file\u2019\u2019')\n"), true)
+}
+
+func TestCompletionUsageStats(t *testing.T) {
+	stats := map[string]int{"root build": 3, "root test": 10, "root lint": 3}
+	root := Action{
+		Trigger: "root",
+		UsageStats: func(path string) int {
+			return stats[path]
+		},
+	}
+	root.AddSubAction(Action{Trigger: "build"})
+	root.AddSubAction(Action{Trigger: "lint"})
+	root.AddSubAction(Action{Trigger: "test"})
+	root.Finalize()
+
+	script, err := root.GenFishCompletion("prog")
+	checkEq(t, err, nil)
+	order := []int{
+		strings.Index(script, "-a 'test'"),
+		strings.Index(script, "-a 'build'"),
+		strings.Index(script, "-a 'lint'"),
+		strings.Index(script, "-a 'help'"),
+	}
+	for i := 1; i < len(order); i++ {
+		checkEq(t, order[i-1] < order[i], true)
+	}
+
+	script, err = root.GenPowerShellCompletion("prog")
+	checkEq(t, err, nil)
+	checkEq(t, strings.Contains(script,
+		"            ,@('test', 'test')\n            ,@('build', 'build')\n            ,@('lint', 'lint')\n"), true)
+}
@@ -0,0 +1,14 @@
+package argo
+
+// DryRun reports which Actions would be triggered by Parse() with args, without calling any Do
+// path lists Triggers of the triggered Actions in order, Default Actions without Trigger are not listed
+// consumed is the args which would be consumed by the last triggered Action
+// It walks the Action tree as Parse() does, including Preprocess, and returns the same errors,
+// but no output is written, and OnError, OnUnmatched, PromptFunc and Tracer are not called
+// Help is never shown, DryRun stops at the Action which would show help for HelpFlag or HelpBeforeConsume,
+// or show Version for "--version"
+func (act Action) DryRun(args []string) (path []string, consumed []string, err error) {
+	state := &State{dryRun: true}
+	err = act.Parse(state, args)
+	return state.dryPath, state.doArgs, err
+}
@@ -0,0 +1,113 @@
+package argo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	triggered := []string{}
+	var lastArgs []string
+	record := func(state *State, _ ...interface{}) error {
+		triggered = append(triggered, state.path)
+		lastArgs = state.Args()
+		return nil
+	}
+
+	root := Action{Trigger: "root", Do: record, AllowAbbrev: true}
+	file := Action{Trigger: "file", MaxConsume: 1, Do: record}
+	file.AddSubAction(Action{Trigger: "cp", MinConsume: 2, MaxConsume: 2, Do: record})
+	root.AddSubAction(file)
+	root.Default = &Action{Do: record}
+	checkEq(t, root.Finalize(), nil)
+
+	cases := [][]string{
+		{"root"},
+		{"root", "file", "a", "cp", "x", "y", "z"},
+		{"root", "fi", "a"},
+		{"root", "unknown", "a", "b"},
+	}
+	for _, args := range cases {
+		triggered = []string{}
+		lastArgs = nil
+		checkEq(t, root.Parse(&State{}, args), nil)
+
+		path, consumed, err := root.DryRun(args)
+		checkEq(t, err, nil)
+		checkEq(t, strings.Join(path, " "), triggered[len(triggered)-1])
+		checkEq(t, consumed, lastArgs)
+	}
+
+	triggered = []string{}
+	path, consumed, err := root.DryRun([]string{"root", "file", "a", "cp", "x"})
+	checkTypeEq(t, err, TooFewArgsError{})
+	checkEq(t, path, []string{"root", "file", "cp"})
+	checkEq(t, consumed, []string(nil))
+	checkEq(t, triggered, []string{})
+
+	path, _, err = root.DryRun([]string{"other"})
+	checkEq(t, err, nil)
+	checkEq(t, path, []string(nil))
+
+	// Help is not shown, while Parse() shows it
+	helped := 0
+	root.HelpDo = func(state *State, act *Action, target *Action) {
+		helped++
+		state.OutputStr.WriteString(target.Help())
+	}
+	checkEq(t, root.Refinalize(), nil)
+
+	triggered = []string{}
+	path, consumed, err = root.DryRun([]string{"root", "help"})
+	checkEq(t, err, nil)
+	checkEq(t, path, []string{"root", "help"})
+	checkEq(t, consumed, []string{})
+	checkEq(t, helped, 0)
+	checkEq(t, triggered, []string{})
+
+	state := State{}
+	checkEq(t, root.Parse(&state, []string{"root", "help"}), nil)
+	checkEq(t, helped, 1)
+	checkEq(t, state.OutputStr.String(), root.Help())
+}
+
+func TestDryRunErrors(t *testing.T) {
+	called := []string{}
+	root := Action{
+		Trigger:               "git",
+		RejectUnknownTriggers: true,
+		Preprocess: func(args []string) ([]string, error) {
+			return append([]string{args[0]}, strings.Fields(strings.Join(args[1:], " "))...), nil
+		},
+		OnError: func(*State, error) {
+			called = append(called, "OnError")
+		},
+		Tracer: func(TraceEvent) {
+			called = append(called, "Tracer")
+		},
+	}
+	remote := Action{
+		Trigger:          "remote",
+		RequireSubAction: true,
+		Do: func(*State, ...interface{}) error {
+			called = append(called, "Do")
+			return nil
+		},
+	}
+	remote.AddSubAction(Action{Trigger: "add", MinConsume: 1})
+	root.AddSubAction(remote)
+	checkEq(t, root.Finalize(), nil)
+
+	path, consumed, err := root.DryRun([]string{"git", "remote add origin"})
+	checkEq(t, err, nil)
+	checkEq(t, path, []string{"git", "remote", "add"})
+	checkEq(t, consumed, []string{"origin"})
+
+	path, _, err = root.DryRun([]string{"git", "remote"})
+	checkTypeEq(t, err, SubActionRequiredError{})
+	checkEq(t, path, []string{"git", "remote"})
+
+	_, _, err = root.DryRun([]string{"git", "rebase"})
+	checkTypeEq(t, err, UnknownTriggerError{})
+	checkEq(t, called, []string{})
+}
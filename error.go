@@ -1,5 +1,7 @@
 package argo
 
+import "errors"
+
 // Err is the common base type for all errors that are reported by Argo package
 // This can be used to quickly identify whether a returned error comes from Argo
 type Err struct {
@@ -8,3 +10,13 @@ type Err struct {
 func (e Err) Error() string {
 	return ""
 }
+
+// Code returns a stable identifier of the error, such as "argo.too_few_args"
+// Each error type of Argo overrides it with its own identifier
+func (e Err) Code() string {
+	return "argo.error"
+}
+
+// ErrShowHelp can be returned by Action.Do() to show help text of the Action instead of failing, it can be wrapped
+// Parse() writes help text of the Action to State.OutputStr, then stops parsing and returns nil
+var ErrShowHelp = errors.New("argo: show help")
@@ -0,0 +1,30 @@
+package argo
+
+import "testing"
+
+func TestErrorCode(t *testing.T) {
+	type coder interface {
+		Code() string
+	}
+
+	cases := []struct {
+		err  error
+		code string
+	}{
+		{TooFewArgsError{}, "argo.too_few_args"},
+		{ActionAlreadyAssginedError{}, "argo.action_already_assigned"},
+		{AmbiguousTriggerError{}, "argo.ambiguous_trigger"},
+		{UnterminatedQuoteError{}, "argo.unterminated_quote"},
+		{ArgConversionError{}, "argo.arg_conversion"},
+		{DuplicateOptionError{}, "argo.duplicate_option"},
+		{Err{}, "argo.error"},
+	}
+	for _, c := range cases {
+		checkEq(t, c.err.(coder).Code(), c.code)
+	}
+
+	root := Action{Trigger: "root", MinConsume: 1}
+	root.Finalize()
+	err := root.Parse(&State{}, []string{"root"})
+	checkEq(t, err.(coder).Code(), "argo.too_few_args")
+}
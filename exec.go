@@ -0,0 +1,61 @@
+package argo
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// ExitCodeError indicates an external program run by an ExecAction exited with a non-zero code
+type ExitCodeError struct {
+	Err
+	Path     string
+	Program  string
+	ExitCode int
+}
+
+func (e ExitCodeError) Error() string {
+	return fmt.Sprintf("Execution Error: %s exited with code %d\nActionPath: %s", e.Program, e.ExitCode, e.Path)
+}
+
+func (ExitCodeError) Code() string {
+	return "argo.exit_code"
+}
+
+// execProgram runs program with args, writing its stdout into stdout, and returns its exit code
+// It is a variable so that tests can replace it
+var execProgram = func(program string, args []string, stdout io.Writer) (int, error) {
+	cmd := exec.Command(program, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	}
+	return 0, err
+}
+
+// ExecAction creates an Action which runs the external `program` with all consumed args
+// stdout of the program is written into State.OutputStr
+// A non-zero exit code is returned as ExitCodeError
+// Help flags are passed to the program as well, as DisableHelp is set
+func ExecAction(trigger string, program string) Action {
+	return Action{
+		Trigger:     trigger,
+		MaxConsume:  -1,
+		DisableHelp: true,
+		Do: func(state *State, _ ...interface{}) error {
+			code, err := execProgram(program, state.Args(), &state.OutputStr)
+			if err != nil {
+				return err
+			}
+
+			if code != 0 {
+				return ExitCodeError{Path: state.path, Program: program, ExitCode: code}
+			}
+			return nil
+		},
+	}
+}
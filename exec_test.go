@@ -0,0 +1,44 @@
+package argo
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestExecAction(t *testing.T) {
+	origExec := execProgram
+	defer func() { execProgram = origExec }()
+
+	var gotProgram string
+	var gotArgs []string
+	execProgram = func(program string, args []string, stdout io.Writer) (int, error) {
+		gotProgram = program
+		gotArgs = args
+		io.WriteString(stdout, strings.Join(args, ","))
+		if len(args) > 0 && args[0] == "fail" {
+			return 3, nil
+		}
+		return 0, nil
+	}
+
+	root := Action{Trigger: "root"}
+	root.AddSubAction(ExecAction("ls", "/bin/ls"))
+	checkEq(t, root.Finalize(), nil)
+
+	state := State{}
+	checkEq(t, root.Parse(&state, []string{"root", "ls", "-l", "dir"}), nil)
+	checkEq(t, gotProgram, "/bin/ls")
+	checkEq(t, gotArgs, []string{"-l", "dir"})
+	checkEq(t, state.OutputStr.String(), "-l,dir")
+
+	// Help flags are passed to the program
+	state = State{}
+	checkEq(t, root.Parse(&state, []string{"root", "ls", "-h", "--help"}), nil)
+	checkEq(t, gotArgs, []string{"-h", "--help"})
+
+	state = State{}
+	err := root.Parse(&state, []string{"root", "ls", "fail"})
+	checkEq(t, err, ExitCodeError{Path: "root ls", Program: "/bin/ls", ExitCode: 3})
+	checkEq(t, state.OutputStr.String(), "fail")
+}
@@ -0,0 +1,47 @@
+package argo
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Execute is the single-call entry point for a program using this Action as its root
+// It finalizes the Action tree if not finalized yet, parses os.Args, prints outputs to stdout and errors to stderr
+// The program name in os.Args[0] is treated as the Trigger of this Action
+// The returned error is the one returned by Finalize() or Parse(), it is left for the caller to handle
+func (act *Action) Execute() error {
+	return act.execute(os.Args, os.Stdout, os.Stderr)
+}
+
+func (act *Action) execute(args []string, stdout io.Writer, stderr io.Writer) error {
+	if !act.finalized {
+		if err := act.Finalize(); err != nil {
+			fmt.Fprintln(stderr, err)
+			return err
+		}
+	}
+
+	parseArgs := []string{act.Trigger}
+	if len(args) > 1 {
+		parseArgs = append(parseArgs, args[1:]...)
+	}
+
+	state := &State{}
+	err := act.Parse(state, parseArgs)
+
+	output := state.OutputStr.String()
+	if output != "" {
+		if !strings.HasSuffix(output, "\n") {
+			output += "\n"
+		}
+		io.WriteString(stdout, output)
+	}
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+	}
+
+	return err
+}
@@ -0,0 +1,46 @@
+package argo
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExecute(t *testing.T) {
+	act := Action{
+		Trigger: "prog",
+	}
+
+	act.AddSubAction(Action{
+		Trigger:    "echo",
+		MaxConsume: -1,
+		Do: func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString(state.Args()[0])
+			return nil
+		},
+	})
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	err := act.execute([]string{"/usr/bin/prog", "echo", "hello"}, stdout, stderr)
+	checkEq(t, err, nil)
+	checkEq(t, act.finalized, true)
+	checkEq(t, stdout.String(), "hello\n")
+	checkEq(t, stderr.String(), "")
+}
+
+func TestExecuteError(t *testing.T) {
+	act := Action{
+		Trigger: "prog",
+		Do: func(_ *State, _ ...interface{}) error {
+			return CustomError{}
+		},
+	}
+	checkEq(t, act.Finalize(), nil)
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	err := act.execute([]string{"prog"}, stdout, stderr)
+	checkTypeEq(t, err, CustomError{})
+	checkEq(t, stdout.String(), "")
+	checkEq(t, stderr.String(), "cerr\n")
+}
@@ -0,0 +1,100 @@
+package argo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// grammarRuleName returns the name of the grammar rule of act, which is its Path() joined with "_"
+func grammarRuleName(act Action) string {
+	return strings.Join(splitPath(act.Path(), act.pathSeparator()), "_")
+}
+
+// grammarArgs returns the grammar terms of args consumed by act
+// Required args are bare names, optional args are in [] and repeatable args are in {}
+func grammarArgs(act Action) []string {
+	terms := []string{}
+	for _, arg := range usageArgs(act) {
+		switch {
+		case arg.variadic && arg.required:
+			terms = append(terms, arg.name, fmt.Sprintf("{%s}", arg.name))
+		case arg.variadic:
+			terms = append(terms, fmt.Sprintf("{%s}", arg.name))
+		case arg.required:
+			terms = append(terms, arg.name)
+		default:
+			terms = append(terms, fmt.Sprintf("[%s]", arg.name))
+		}
+	}
+	return terms
+}
+
+// Grammar returns an EBNF-like grammar of the Action tree, one rule per line, such as
+//
+//	root ::= "root" (root_sub1 | root_sub2) ;
+//	root_sub1 ::= "sub1" arg1 [arg2] ;
+//
+// Rules are named after Path() of Actions, and listed by depth-first traversal in registration order
+// The SubAction group is optional if the Action has Do or Default, and Default is matched without its Trigger
+// Hidden Actions and injected help SubActions are skipped
+func (act *Action) Grammar() string {
+	rules := []string{}
+
+	var walk func(act Action, name string, isDefault bool)
+	walk = func(act Action, name string, isDefault bool) {
+		terms := []string{}
+		if !isDefault {
+			terms = append(terms, fmt.Sprintf("%q", act.Trigger))
+		}
+		if act.MaxConsume != 0 {
+			terms = append(terms, grammarArgs(act)...)
+		}
+
+		subs := []Action{}
+		subNames := []string{}
+		for _, trigger := range act.SubActions() {
+			sub := act.GetSubAction(trigger)
+			if sub.Hidden || sub.injected {
+				continue
+			}
+			subs = append(subs, sub)
+			subNames = append(subNames, grammarRuleName(sub))
+		}
+
+		defaultName := name + "_default"
+		hasDefault := act.Default != nil && !act.Default.Hidden
+		if hasDefault {
+			subNames = append(subNames, defaultName)
+		}
+
+		if len(subNames) > 0 {
+			if act.MaxConsume < 0 && act.ConsumeUntil != "" {
+				terms = append(terms, fmt.Sprintf("%q", act.ConsumeUntil))
+			}
+
+			group := strings.Join(subNames, " | ")
+			if act.Do != nil || act.Default != nil {
+				terms = append(terms, "["+group+"]")
+			} else if len(subNames) > 1 {
+				terms = append(terms, "("+group+")")
+			} else {
+				terms = append(terms, group)
+			}
+		}
+
+		if len(terms) == 0 {
+			terms = append(terms, `""`)
+		}
+		rules = append(rules, fmt.Sprintf("%s ::= %s ;", name, strings.Join(terms, " ")))
+
+		for index, sub := range subs {
+			walk(sub, subNames[index], false)
+		}
+		if hasDefault {
+			walk(*act.Default, defaultName, true)
+		}
+	}
+	walk(*act, grammarRuleName(*act), false)
+
+	return strings.Join(rules, "\n")
+}
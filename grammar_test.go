@@ -0,0 +1,29 @@
+package argo
+
+import "testing"
+
+func TestGrammar(t *testing.T) {
+	root := Action{Trigger: "git"}
+	remote := Action{Trigger: "remote", Do: func(*State, ...interface{}) error { return nil }}
+	remote.AddSubAction(Action{Trigger: "add", MinConsume: 2, ArgNames: []string{"name", "url"}})
+	remote.AddSubAction(Action{Trigger: "remove", MinConsume: 1, ArgNames: []string{"name"}})
+	root.AddSubAction(remote)
+	root.AddSubAction(Action{Trigger: "log", MaxConsume: 1, ArgNames: []string{"rev"}})
+	root.AddSubAction(Action{Trigger: "add", Consume: "1..", VariadicName: "paths"})
+	root.AddSubAction(Action{Trigger: "internal", Hidden: true})
+	checkEq(t, root.Finalize(), nil)
+
+	checkEq(t, root.Grammar(), `git ::= "git" (git_remote | git_log | git_add) ;
+git_remote ::= "remote" [git_remote_add | git_remote_remove] ;
+git_remote_add ::= "add" name url ;
+git_remote_remove ::= "remove" name ;
+git_log ::= "log" [rev] ;
+git_add ::= "add" paths {paths} ;`)
+
+	run := Action{Trigger: "run", Default: &Action{Trigger: "script"}}
+	run.AddSubAction(Action{Trigger: "test"})
+	checkEq(t, run.Finalize(), nil)
+	checkEq(t, run.Grammar(), `run ::= "run" [run_test | run_default] ;
+run_test ::= "test" ;
+run_default ::= {argN} ;`)
+}
@@ -0,0 +1,154 @@
+package argo
+
+import "strings"
+
+// CommandEntry describes an invocable Action in the Action tree
+type CommandEntry struct {
+	Path       string
+	MinConsume int
+	MaxConsume int
+	ArgNames   []string
+	ShortDescr string
+}
+
+// isInvocable checks if act does something when triggered, which is having a Do or being a leaf
+func isInvocable(act Action) bool {
+	if act.Do != nil {
+		return true
+	}
+
+	for _, trigger := range act.SubActions() {
+		if !act.GetSubAction(trigger).injected {
+			return false
+		}
+	}
+	return act.Default == nil
+}
+
+// Index returns entries of all invocable Actions in the Action tree, including this Action
+// Entries are ordered by depth-first traversal in registration order, Default Actions come after SubActions
+// Hidden Actions and their SubActions are skipped unless includeHidden is true
+// Injected help and commands SubActions are always skipped
+func (act *Action) Index(includeHidden bool) []CommandEntry {
+	entries := []CommandEntry{}
+
+	var walk func(act Action, path string)
+	walk = func(act Action, path string) {
+		if act.injected || (act.Hidden && !includeHidden) {
+			return
+		}
+
+		if isInvocable(act) {
+			entries = append(entries, CommandEntry{
+				Path:       path,
+				MinConsume: act.MinConsume,
+				MaxConsume: act.MaxConsume,
+				ArgNames:   act.ArgNames,
+				ShortDescr: act.ShortDescr,
+			})
+		}
+
+		for _, trigger := range act.SubActions() {
+			walk(act.GetSubAction(trigger), path+act.pathSeparator()+trigger)
+		}
+
+		if act.Default != nil {
+			defaultPath := path
+			if act.Default.Trigger != "" {
+				defaultPath += act.pathSeparator() + act.Default.Trigger
+			}
+			walk(*act.Default, defaultPath)
+		}
+	}
+	walk(*act, act.Trigger)
+
+	return entries
+}
+
+// CommandInfo describes an Action in the Action tree
+type CommandInfo struct {
+	Path       string
+	ShortDescr string
+	Hidden     bool
+}
+
+// ListCommands returns info of all Actions in the finalized Action tree, including this Action
+// Actions are ordered by depth-first pre-order traversal in registration order, Default Actions come after SubActions
+// Hidden Actions are listed with Hidden set. Injected help and commands SubActions are listed only if includeHelp is true
+func (act Action) ListCommands(includeHelp bool) []CommandInfo {
+	commands := []CommandInfo{}
+
+	var walk func(act Action)
+	walk = func(act Action) {
+		if act.injected && !includeHelp {
+			return
+		}
+
+		commands = append(commands, CommandInfo{
+			Path:       act.Path(),
+			ShortDescr: act.ShortDescr,
+			Hidden:     act.Hidden,
+		})
+
+		for _, trigger := range act.SubActions() {
+			walk(act.GetSubAction(trigger))
+		}
+
+		if act.Default != nil {
+			walk(*act.Default)
+		}
+	}
+	walk(act)
+
+	return commands
+}
+
+// HelpTree returns a tree of all visible Actions in the finalized Action tree, including this Action
+// Each line shows the Trigger of an Action, indented by its depth, with its ShortDescr aligned after it
+// Hidden Actions and their SubActions, and injected help and commands SubActions are skipped
+func (act Action) HelpTree() string {
+	entries := []CommandEntry{}
+
+	var walk func(act Action, depth int)
+	walk = func(act Action, depth int) {
+		if act.Hidden || act.injected {
+			return
+		}
+
+		entries = append(entries, CommandEntry{
+			Path:       strings.Repeat("  ", depth) + act.Trigger,
+			ShortDescr: act.ShortDescr,
+		})
+
+		for _, trigger := range act.SubActions() {
+			walk(act.GetSubAction(trigger), depth+1)
+		}
+
+		if act.Default != nil && act.Default.Trigger != "" {
+			walk(*act.Default, depth+1)
+		}
+	}
+	walk(act, 0)
+
+	return commandsList(entries)
+}
+
+// commandsList formats entries as lines of paths and short descriptions, with descriptions aligned
+func commandsList(entries []CommandEntry) string {
+	width := 0
+	for _, entry := range entries {
+		if displayWidth(entry.Path) > width {
+			width = displayWidth(entry.Path)
+		}
+	}
+
+	lines := []string{}
+	for _, entry := range entries {
+		if entry.ShortDescr == "" {
+			lines = append(lines, entry.Path)
+		} else {
+			lines = append(lines, padRight(entry.Path, width)+"  "+entry.ShortDescr)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,139 @@
+package argo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	noop := func(*State, ...interface{}) error { return nil }
+
+	root := Action{Trigger: "root"}
+	file := Action{Trigger: "file", ShortDescr: "file commands"}
+	file.AddSubAction(Action{
+		Trigger:    "cp",
+		ShortDescr: "copy",
+		MinConsume: 2,
+		MaxConsume: 2,
+		ArgNames:   []string{"src", "dst"},
+		Do:         noop,
+	})
+	file.AddSubAction(Action{Trigger: "secret", Hidden: true, Do: noop})
+	root.AddSubAction(file)
+	root.AddSubAction(Action{Trigger: "version", ShortDescr: "show version"})
+	checkEq(t, root.Finalize(), nil)
+
+	checkEq(t, root.Index(false), []CommandEntry{
+		{Path: "root file cp", MinConsume: 2, MaxConsume: 2, ArgNames: []string{"src", "dst"}, ShortDescr: "copy"},
+		{Path: "root version", ShortDescr: "show version"},
+	})
+
+	entries := root.Index(true)
+	checkEq(t, len(entries), 3)
+	checkEq(t, entries[1].Path, "root file secret")
+}
+
+func TestEnableCommandsList(t *testing.T) {
+	root := Action{Trigger: "app", EnableCommandsList: true}
+	file := Action{Trigger: "file"}
+	file.AddSubAction(Action{Trigger: "cp", ShortDescr: "copy files"})
+	file.AddSubAction(Action{Trigger: "secret", Hidden: true})
+	root.AddSubAction(file)
+	root.AddSubAction(Action{Trigger: "version"})
+	checkEq(t, root.Finalize(), nil)
+	checkEq(t, root.SubActions(), []string{"file", "version", "commands", "help"})
+
+	state := State{}
+	checkEq(t, root.Parse(&state, []string{"app", "commands"}), nil)
+	checkEq(t, state.OutputStr.String(), "app file cp  copy files\napp version")
+
+	override := Action{Trigger: "app", EnableCommandsList: true, CommandsTrigger: "list"}
+	override.AddSubAction(Action{Trigger: "list", ShortDescr: "user list"})
+	checkEq(t, override.Finalize(), nil)
+	checkEq(t, override.GetSubAction("list").ShortDescr, "user list")
+}
+
+func TestListCommands(t *testing.T) {
+	root := Action{Trigger: "root", ShortDescr: "root commands"}
+	file := Action{Trigger: "file", ShortDescr: "file commands"}
+	file.AddSubAction(Action{Trigger: "cp", ShortDescr: "copy"})
+	file.AddSubAction(Action{Trigger: "secret", Hidden: true})
+	root.AddSubAction(file)
+	root.AddSubAction(Action{Trigger: "version", ShortDescr: "show version", DisableHelp: true})
+	root.Default = &Action{Trigger: "run", ShortDescr: "run script", DisableHelp: true}
+	checkEq(t, root.Finalize(), nil)
+
+	checkEq(t, root.ListCommands(false), []CommandInfo{
+		{Path: "root", ShortDescr: "root commands"},
+		{Path: "root file", ShortDescr: "file commands"},
+		{Path: "root file cp", ShortDescr: "copy"},
+		{Path: "root file secret", Hidden: true},
+		{Path: "root version", ShortDescr: "show version"},
+		{Path: "root run", ShortDescr: "run script"},
+	})
+
+	paths := []string{}
+	for _, command := range root.ListCommands(true) {
+		paths = append(paths, command.Path)
+	}
+	checkEq(t, paths, []string{
+		"root", "root file", "root file cp", "root file cp help", "root file secret", "root file secret help",
+		"root file help", "root version", "root help", "root run",
+	})
+}
+
+func TestCommandsListAlignment(t *testing.T) {
+	// column returns the display column where descr starts in each line of list
+	column := func(list string, descr []string) []int {
+		columns := []int{}
+		for index, line := range strings.Split(list, "\n") {
+			columns = append(columns, displayWidth(line[:strings.LastIndex(line, descr[index])]))
+		}
+		return columns
+	}
+
+	ascii := commandsList([]CommandEntry{
+		{Path: "app ls", ShortDescr: "list"},
+		{Path: "app remove", ShortDescr: "remove"},
+	})
+	checkEq(t, ascii, "app ls      list\napp remove  remove")
+	checkEq(t, column(ascii, []string{"list", "remove"}), []int{12, 12})
+
+	mixed := commandsList([]CommandEntry{
+		{Path: "app 列表", ShortDescr: "list"},
+		{Path: "app remove", ShortDescr: "remove"},
+		{Path: "app café", ShortDescr: "coffee"},
+	})
+	checkEq(t, mixed, "app 列表    list\napp remove  remove\napp café    coffee")
+	checkEq(t, column(mixed, []string{"list", "remove", "coffee"}), []int{12, 12, 12})
+}
+
+func TestHelpTree(t *testing.T) {
+	root := Action{Trigger: "git", ShortDescr: "version control"}
+	remote := Action{Trigger: "remote", ShortDescr: "manage remotes"}
+	remote.AddSubAction(Action{Trigger: "add", ShortDescr: "add a remote"})
+	remote.AddSubAction(Action{Trigger: "prune", Hidden: true})
+	remote.AddSubAction(Action{Trigger: "show"})
+	root.AddSubAction(remote)
+	root.AddSubAction(Action{Trigger: "log", ShortDescr: "show commit logs"})
+	root.Default = &Action{Trigger: "alias", ShortDescr: "run an alias"}
+	checkEq(t, root.Finalize(), nil)
+
+	tree := root.HelpTree()
+	checkEq(t, tree, `git       version control
+  remote  manage remotes
+    add   add a remote
+    show
+  log     show commit logs
+  alias   run an alias`)
+
+	for _, trigger := range []string{"git", "remote", "add", "show", "log", "alias"} {
+		count := 0
+		for _, line := range strings.Split(tree, "\n") {
+			if strings.Fields(line)[0] == trigger {
+				count++
+			}
+		}
+		checkEq(t, count, 1)
+	}
+}
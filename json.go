@@ -0,0 +1,137 @@
+package argo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// actionJSON is the serializable structure of an Action tree
+type actionJSON struct {
+	Trigger    string       `json:"trigger"`
+	ShortDescr string       `json:"shortDescr"`
+	LongDescr  string       `json:"longDescr"`
+	ArgNames   []string     `json:"argNames"`
+	MinConsume int          `json:"minConsume"`
+	MaxConsume int          `json:"maxConsume"`
+	Hidden     bool         `json:"hidden"`
+	Options    []optionJSON `json:"options,omitempty"`
+	Default    *actionJSON  `json:"default,omitempty"`
+	SubActions []actionJSON `json:"subActions"`
+}
+
+// optionJSON is the serializable structure of an Option
+type optionJSON struct {
+	Name       string `json:"name"`
+	Short      string `json:"short,omitempty"`
+	HasValue   bool   `json:"hasValue"`
+	Repeatable bool   `json:"repeatable"`
+	Global     bool   `json:"global"`
+	Descr      string `json:"descr"`
+}
+
+func newActionJSON(act Action) actionJSON {
+	ret := actionJSON{
+		Trigger:    act.Trigger,
+		ShortDescr: act.ShortDescr,
+		LongDescr:  act.LongDescr,
+		ArgNames:   append([]string{}, act.ArgNames...),
+		MinConsume: act.MinConsume,
+		MaxConsume: act.MaxConsume,
+		Hidden:     act.Hidden,
+		SubActions: []actionJSON{},
+	}
+
+	for _, option := range act.Options {
+		ret.Options = append(ret.Options, optionJSON{
+			Name:       option.Name,
+			Short:      option.Short,
+			HasValue:   option.HasValue,
+			Repeatable: option.Repeatable,
+			Global:     option.Global,
+			Descr:      option.Descr,
+		})
+	}
+
+	if act.Default != nil {
+		def := newActionJSON(*act.Default)
+		ret.Default = &def
+	}
+
+	for _, sub := range act.SubActions() {
+		subAct := act.GetSubAction(sub)
+		if subAct.injected {
+			continue
+		}
+		ret.SubActions = append(ret.SubActions, newActionJSON(subAct))
+	}
+
+	return ret
+}
+
+// MarshalJSON exports the structure of this Action and all SubActions as JSON
+// Function members, such as Do and HelpGen, and SubActions injected by Finalize(), such as help, are not exported
+// The output is read-only metadata and cannot be used to reconstruct the Action tree
+func (act Action) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newActionJSON(act))
+}
+
+// helpJSON is the serializable help information of an Action
+type helpJSON struct {
+	Usage      string              `json:"usage"`
+	ShortDescr string              `json:"shortDescr"`
+	LongDescr  string              `json:"longDescr"`
+	Args       []helpArgJSON       `json:"args"`
+	SubActions []helpSubActionJSON `json:"subActions"`
+}
+
+type helpArgJSON struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required"`
+	Variadic bool   `json:"variadic"`
+}
+
+type helpSubActionJSON struct {
+	Trigger    string `json:"trigger"`
+	ShortDescr string `json:"shortDescr"`
+}
+
+// HelpJSON returns help information of this Action as JSON, for rendering help text by other programs
+// SubActions are listed in the same order as the help text
+func (act Action) HelpJSON() ([]byte, error) {
+	help := helpJSON{
+		Usage:      act.Usage(),
+		ShortDescr: act.ShortDescr,
+		LongDescr:  act.LongDescr,
+		Args:       []helpArgJSON{},
+		SubActions: []helpSubActionJSON{},
+	}
+
+	if act.MaxConsume != 0 {
+		for _, arg := range usageArgs(act) {
+			help.Args = append(help.Args, helpArgJSON{
+				Name:     arg.name,
+				Required: arg.required,
+				Variadic: arg.variadic,
+			})
+		}
+	}
+
+	for _, sub := range helpSubActions(act) {
+		help.SubActions = append(help.SubActions, helpSubActionJSON{
+			Trigger:    sub.Trigger,
+			ShortDescr: sub.ShortDescr,
+		})
+	}
+
+	return json.Marshal(help)
+}
+
+// TreeHash returns a SHA-256 hex digest of the structure of this Action tree
+// It covers the same fields as MarshalJSON(), including Options and Default, so it changes whenever the exported structure changes
+// SubActions injected by Finalize() are skipped, so the digest is the same before and after Finalize()
+func (act *Action) TreeHash() string {
+	data, _ := json.Marshal(newActionJSON(*act))
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
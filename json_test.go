@@ -0,0 +1,141 @@
+package argo
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	root := Action{
+		Trigger:    "root",
+		ShortDescr: "root short",
+		Do: func(_ *State, _ ...interface{}) error {
+			return nil
+		},
+	}
+	root.AddSubAction(Action{
+		Trigger:    "sub",
+		LongDescr:  "sub long",
+		ArgNames:   []string{"a1", "a2"},
+		MinConsume: 1,
+		MaxConsume: 2,
+		Hidden:     true,
+	})
+	checkEq(t, root.Finalize(), nil)
+
+	data, err := json.Marshal(root)
+	checkEq(t, err, nil)
+
+	// Injected help SubActions are not exported
+	var decoded actionJSON
+	checkEq(t, json.Unmarshal(data, &decoded), nil)
+	checkEq(t, decoded, actionJSON{
+		Trigger:    "root",
+		ShortDescr: "root short",
+		ArgNames:   []string{},
+		SubActions: []actionJSON{
+			{
+				Trigger:    "sub",
+				LongDescr:  "sub long",
+				ArgNames:   []string{"a1", "a2"},
+				MinConsume: 1,
+				MaxConsume: 2,
+				Hidden:     true,
+				SubActions: []actionJSON{},
+			},
+		},
+	})
+
+	again, err := json.Marshal(decoded)
+	checkEq(t, err, nil)
+	checkEq(t, string(again), string(data))
+
+	var raw map[string]interface{}
+	checkEq(t, json.Unmarshal(data, &raw), nil)
+	_, ok := raw["do"]
+	checkEq(t, ok, false)
+	_, ok = raw["helpGen"]
+	checkEq(t, ok, false)
+}
+
+func TestHelpJSON(t *testing.T) {
+	root := Action{
+		Trigger:    "root",
+		ShortDescr: "root short",
+	}
+	root.AddSubAction(Action{
+		Trigger:    "sub",
+		ShortDescr: "sub short",
+		LongDescr:  "sub long",
+		ArgNames:   []string{"src", "dst"},
+		MinConsume: 1,
+		MaxConsume: 2,
+	})
+	checkEq(t, root.Finalize(), nil)
+
+	data, err := root.HelpJSON()
+	checkEq(t, err, nil)
+	var help helpJSON
+	checkEq(t, json.Unmarshal(data, &help), nil)
+	checkEq(t, help, helpJSON{
+		Usage:      "root [sub-action]",
+		ShortDescr: "root short",
+		Args:       []helpArgJSON{},
+		SubActions: []helpSubActionJSON{
+			{Trigger: "sub", ShortDescr: "sub short"},
+			{Trigger: "help", ShortDescr: "Display help for commands"},
+		},
+	})
+
+	data, err = root.GetSubAction("sub").HelpJSON()
+	checkEq(t, err, nil)
+	help = helpJSON{}
+	checkEq(t, json.Unmarshal(data, &help), nil)
+	checkEq(t, help, helpJSON{
+		Usage:      "root sub <src> [dst]",
+		ShortDescr: "sub short",
+		LongDescr:  "sub long",
+		Args: []helpArgJSON{
+			{Name: "src", Required: true},
+			{Name: "dst"},
+		},
+		SubActions: []helpSubActionJSON{},
+	})
+}
+
+func TestTreeHash(t *testing.T) {
+	newTree := func() Action {
+		root := Action{Trigger: "root", ShortDescr: "the root"}
+		root.AddSubAction(Action{Trigger: "sub", MinConsume: 1, MaxConsume: 2, ArgNames: []string{"a"}})
+		root.AddSubAction(Action{Trigger: "other", Do: func(*State, ...interface{}) error { return nil }})
+		return root
+	}
+
+	root := newTree()
+	hash := root.TreeHash()
+	checkEq(t, len(hash), 64)
+	for i := 0; i < 10; i++ {
+		again := newTree()
+		checkEq(t, again.TreeHash(), hash)
+	}
+
+	root.AddSubAction(Action{Trigger: "new"})
+	checkNe(t, root.TreeHash(), hash)
+
+	changed := newTree()
+	changed.ShortDescr = "changed"
+	checkNe(t, changed.TreeHash(), hash)
+
+	// Injected help SubActions do not change the digest
+	finalized := newTree()
+	checkEq(t, finalized.Finalize(), nil)
+	checkEq(t, finalized.TreeHash(), hash)
+
+	changed = newTree()
+	changed.Options = []Option{{Name: "verbose", Short: "v"}}
+	checkNe(t, changed.TreeHash(), hash)
+
+	changed = newTree()
+	changed.Default = &Action{MaxConsume: 1}
+	checkNe(t, changed.TreeHash(), hash)
+}
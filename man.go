@@ -0,0 +1,74 @@
+package argo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// manEscape escapes text for roff
+func manEscape(text string) string {
+	lines := strings.Split(text, "\n")
+	for index, line := range lines {
+		line = strings.ReplaceAll(line, `\`, `\e`)
+		line = strings.ReplaceAll(line, "-", `\-`)
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			line = `\&` + line
+		}
+		lines[index] = line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// GenManPage generates a man page in roff format for this Action in manual section `section`
+// All visible SubActions are listed in the COMMANDS section, Hidden SubActions and injected help SubActions are skipped
+func (act Action) GenManPage(section int) (string, error) {
+	if !act.finalized {
+		return "", ActionNotFinalizedError{Victim: act}
+	}
+
+	text := strings.Builder{}
+	fmt.Fprintf(&text, ".TH %s %d\n", manEscape(strings.ToUpper(act.Path())), section)
+
+	text.WriteString(".SH NAME\n")
+	text.WriteString(manEscape(act.Path()))
+	if act.ShortDescr != "" {
+		text.WriteString(` \- ` + manEscape(act.ShortDescr))
+	}
+	text.WriteString("\n")
+
+	text.WriteString(".SH SYNOPSIS\n")
+	text.WriteString(manEscape(act.Usage()) + "\n")
+
+	descr := act.LongDescr
+	if descr == "" {
+		descr = act.ShortDescr
+	}
+	if descr != "" {
+		text.WriteString(".SH DESCRIPTION\n")
+		text.WriteString(manEscape(descr) + "\n")
+	}
+
+	commands := strings.Builder{}
+	var genCommands func(act Action)
+	genCommands = func(act Action) {
+		for _, trigger := range act.SubActions() {
+			sub := act.GetSubAction(trigger)
+			if sub.Hidden || sub.injected {
+				continue
+			}
+
+			commands.WriteString(".TP\n")
+			commands.WriteString(".B " + manEscape(sub.Path()) + "\n")
+			commands.WriteString(manEscape(sub.ShortDescr) + "\n")
+			genCommands(sub)
+		}
+	}
+	genCommands(act)
+
+	if commands.Len() > 0 {
+		text.WriteString(".SH COMMANDS\n")
+		text.WriteString(commands.String())
+	}
+
+	return text.String(), nil
+}
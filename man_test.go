@@ -0,0 +1,61 @@
+package argo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenManPage(t *testing.T) {
+	root := Action{
+		Trigger:    "prog",
+		ShortDescr: "a test program",
+		LongDescr:  "prog does things.\n.not a request",
+	}
+	sub := Action{Trigger: "build", ShortDescr: "build the-thing"}
+	sub.AddSubAction(Action{Trigger: "clean", ShortDescr: "clean up"})
+	root.AddSubAction(sub)
+	root.AddSubAction(Action{Trigger: "secret", Hidden: true})
+
+	_, err := root.GenManPage(1)
+	checkTypeEq(t, err, ActionNotFinalizedError{})
+
+	checkEq(t, root.Finalize(), nil)
+	page, err := root.GenManPage(1)
+	checkEq(t, err, nil)
+	checkEq(t, page, `.TH PROG 1
+.SH NAME
+prog \- a test program
+.SH SYNOPSIS
+prog [sub\-action]
+.SH DESCRIPTION
+prog does things.
+\&.not a request
+.SH COMMANDS
+.TP
+.B prog build
+build the\-thing
+.TP
+.B prog build clean
+clean up
+`)
+	checkEq(t, strings.Contains(page, "secret"), false)
+	checkEq(t, strings.Contains(page, "help"), false)
+}
+
+func TestGenManPageSections(t *testing.T) {
+	root := Action{
+		Trigger:    "prog",
+		ShortDescr: "short",
+		LongDescr:  "long description",
+	}
+	root.AddSubAction(Action{Trigger: "run", ShortDescr: "run it", MaxConsume: 1})
+	root.AddSubAction(Action{Trigger: "stop", ShortDescr: "stop it"})
+	checkEq(t, root.Finalize(), nil)
+
+	page, err := root.GenManPage(8)
+	checkEq(t, err, nil)
+	for _, section := range []string{".TH PROG 8\n", ".SH NAME\n", ".SH SYNOPSIS\nprog [sub\\-action]\n",
+		".SH DESCRIPTION\nlong description\n", ".SH COMMANDS\n", ".B prog run\nrun it\n", ".B prog stop\nstop it\n"} {
+		checkEq(t, strings.Contains(page, section), true)
+	}
+}
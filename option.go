@@ -0,0 +1,411 @@
+package argo
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Option defines a named option accepted by an Action, given as `--name value`, `--name=value` or `--name`
+// Options are taken out of args before counting consumed args, and stored into State
+type Option struct {
+	// Name of the option without the leading "--"
+	Name string
+
+	// HasValue makes the option take a value
+	// Otherwise it is a boolean option, which is set to true by `--name` and false by `--no-name`
+	HasValue bool
+
+	// Descr is the description of the option in help text
+	Descr string
+
+	// Short is a single character alias of the option, such as "v" for `-v`
+	// Finalize() fails with InvalidShortOptionError if it is longer
+	// Boolean options with Short can be bundled, such as `-abc` for `-a -b -c`
+	Short string
+
+	// Repeatable allows an option which takes a value to be given multiple times, all values are kept in State
+	// Giving a non-repeatable option with value multiple times causes DuplicateOptionError
+	// Boolean options can always be given multiple times, and the last one wins
+	Repeatable bool
+
+	// Global makes the option accepted by all SubActions of the Action declaring it as well
+	// It is listed under GlobalOptionsTitle in help text of the SubActions
+	Global bool
+}
+
+// String returns how the option is written in help text, such as `--[no-]verbose` or `--output <value>`
+// Short alias is listed first if it is set, such as `-v, --[no-]verbose`
+func (opt Option) String() string {
+	short := ""
+	if opt.Short != "" {
+		short = "-" + opt.Short + ", "
+	}
+
+	if opt.HasValue && opt.Repeatable {
+		return fmt.Sprintf("%s--%s <value> ...", short, opt.Name)
+	}
+	if opt.HasValue {
+		return fmt.Sprintf("%s--%s <value>", short, opt.Name)
+	}
+	return fmt.Sprintf("%s--[no-]%s", short, opt.Name)
+}
+
+// MissingOptionValueError indicates an option which takes a value is the last arg
+type MissingOptionValueError struct {
+	Err
+	Path string
+	Name string
+}
+
+func (e MissingOptionValueError) Error() string {
+	return fmt.Sprintf("Parsing Error: Option --%s requires a value\nActionPath: %s", e.Name, e.Path)
+}
+
+func (MissingOptionValueError) Code() string {
+	return "argo.missing_option_value"
+}
+
+// DuplicateOptionError indicates a non-repeatable option with value is given multiple times
+type DuplicateOptionError struct {
+	Err
+	Path string
+	Name string
+}
+
+func (e DuplicateOptionError) Error() string {
+	return fmt.Sprintf("Parsing Error: Option --%s is given multiple times\nActionPath: %s", e.Name, e.Path)
+}
+
+func (DuplicateOptionError) Code() string {
+	return "argo.duplicate_option"
+}
+
+// UnknownFlagMode decides how args like `--name` matching no option of an Action are handled
+type UnknownFlagMode int
+
+const (
+	// FlagInherit uses the mode of the parent Action, or FlagPassthrough for the root Action
+	FlagInherit UnknownFlagMode = iota
+	// FlagPassthrough keeps unknown flags as positional args, which is the default
+	FlagPassthrough
+	// FlagError fails the Parse() call with UnknownFlagError
+	FlagError
+	// FlagIgnore drops unknown flags
+	FlagIgnore
+)
+
+// UnknownFlagError indicates an arg like `--name` matches no option, when UnknownFlagMode is FlagError
+type UnknownFlagError struct {
+	Err
+	Path string
+	Flag string
+}
+
+func (e UnknownFlagError) Error() string {
+	return fmt.Sprintf("Parsing Error: Unknown option %s\nActionPath: %s", e.Flag, e.Path)
+}
+
+func (UnknownFlagError) Code() string {
+	return "argo.unknown_flag"
+}
+
+// BundledValueOptionError indicates an option which takes a value is bundled with other short options
+type BundledValueOptionError struct {
+	Err
+	Path string
+	Arg  string
+	Name string
+}
+
+func (e BundledValueOptionError) Error() string {
+	return fmt.Sprintf("Parsing Error: Option --%s takes a value and cannot be bundled in %s\nActionPath: %s",
+		e.Name, e.Arg, e.Path)
+}
+
+func (BundledValueOptionError) Code() string {
+	return "argo.bundled_value_option"
+}
+
+// OptionConflictError indicates an Action declares more than one option with the same name or Short alias
+type OptionConflictError struct {
+	Err
+	Path string
+	Flag string
+}
+
+func (e OptionConflictError) Error() string {
+	return fmt.Sprintf("Option %s is declared more than once in Action: %s", e.Flag, e.Path)
+}
+
+func (OptionConflictError) Code() string {
+	return "argo.option_conflict"
+}
+
+// InvalidShortOptionError indicates Short of an option is not a single character
+type InvalidShortOptionError struct {
+	Err
+	Path  string
+	Name  string
+	Short string
+}
+
+func (e InvalidShortOptionError) Error() string {
+	return fmt.Sprintf("Short alias %q of option --%s is not a single character in Action: %s", e.Short, e.Name, e.Path)
+}
+
+func (InvalidShortOptionError) Code() string {
+	return "argo.invalid_short_option"
+}
+
+// FlagSet is a set of options which can be shared by multiple Actions with AddFlags()
+type FlagSet []Option
+
+// AddFlags adds options in fs to Options of act
+// Options conflicting with the existing ones are reported by Finalize() with OptionConflictError
+func (act *Action) AddFlags(fs FlagSet) {
+	act.Options = append(append([]Option{}, act.Options...), fs...)
+}
+
+// checkOptions checks that options of act have unique names and Short aliases, which are single characters
+func (act Action) checkOptions() error {
+	flags := make(map[string]bool)
+	for _, opt := range act.Options {
+		names := []string{"--" + opt.Name}
+		if opt.Short != "" {
+			if utf8.RuneCountInString(opt.Short) != 1 {
+				return InvalidShortOptionError{Path: act.Path(), Name: opt.Name, Short: opt.Short}
+			}
+			names = append(names, "-"+opt.Short)
+		}
+
+		for _, name := range names {
+			if flags[name] {
+				return OptionConflictError{Path: act.Path(), Flag: name}
+			}
+			flags[name] = true
+		}
+	}
+	return nil
+}
+
+// optionArg is an option found in an arg
+type optionArg struct {
+	opt      Option
+	value    string
+	hasValue bool
+}
+
+// matchOptions finds options of act given by arg, which is a long option or short options
+// nil is returned if arg is not an option of act
+func (act *Action) matchOptions(arg string) ([]optionArg, error) {
+	if opt, value, hasValue, ok := act.matchOption(arg); ok {
+		return []optionArg{{opt, value, hasValue}}, nil
+	}
+
+	if len(arg) < 2 || arg[0] != '-' || arg[1] == '-' {
+		return nil, nil
+	}
+
+	found := []optionArg{}
+	for _, char := range arg[1:] {
+		opt, ok := act.shortOption(string(char))
+		if !ok {
+			return nil, nil
+		}
+
+		if opt.HasValue {
+			found = append(found, optionArg{opt: opt})
+		} else {
+			found = append(found, optionArg{opt, "true", true})
+		}
+	}
+
+	if len(found) > 1 {
+		for _, item := range found {
+			if item.opt.HasValue {
+				return nil, BundledValueOptionError{Path: act.Path(), Arg: arg, Name: item.opt.Name}
+			}
+		}
+	}
+	return found, nil
+}
+
+// globalOptions returns Global options declared by ancestors of act, which are accepted by act as well
+// Options with the same name as an option of act or a nearer ancestor are skipped
+func (act Action) globalOptions() []Option {
+	names := make(map[string]bool)
+	for _, opt := range act.Options {
+		names[opt.Name] = true
+	}
+
+	opts := []Option{}
+	for parent := act.parent; parent != nil; parent = parent.parent {
+		for _, opt := range parent.Options {
+			if opt.Global && !names[opt.Name] {
+				opts = append(opts, opt)
+				names[opt.Name] = true
+			}
+		}
+	}
+	return opts
+}
+
+// allOptions returns options accepted by act, including Global options of its ancestors
+func (act Action) allOptions() []Option {
+	return append(append([]Option{}, act.Options...), act.globalOptions()...)
+}
+
+// shortOption finds the option of act with Short alias short
+func (act Action) shortOption(short string) (Option, bool) {
+	for _, opt := range act.allOptions() {
+		if opt.Short != "" && opt.Short == short {
+			return opt, true
+		}
+	}
+	return Option{}, false
+}
+
+// matchOption finds the option of act given by arg
+// value is empty and hasValue is false if the value is not given in arg
+func (act Action) matchOption(arg string) (opt Option, value string, hasValue bool, ok bool) {
+	if !strings.HasPrefix(arg, "--") {
+		return Option{}, "", false, false
+	}
+
+	name := arg[2:]
+	if index := strings.Index(name, "="); index >= 0 {
+		name, value, hasValue = name[:index], name[index+1:], true
+	}
+
+	for _, opt := range act.allOptions() {
+		if opt.HasValue && opt.Name == name {
+			return opt, value, hasValue, true
+		}
+
+		if !opt.HasValue && !hasValue {
+			if opt.Name == name {
+				return opt, "true", true, true
+			} else if "no-"+opt.Name == name {
+				return opt, "false", true, true
+			}
+		}
+	}
+	return Option{}, "", false, false
+}
+
+// takeOptions stores options of act found in args into state, and returns the remaining args
+// args[0] is the triggering arg. Options are searched among the args which act would consume and the options between them,
+// the search stops at the first arg after MaxConsume positional args, which could trigger a SubAction, or at ConsumeUntil
+// `--` also stops the search and is dropped, so the following args are kept as positional args even if they look like options
+func (act *Action) takeOptions(state *State, args []string) ([]string, error) {
+	if len(act.allOptions()) == 0 && act.UnknownFlagMode == FlagPassthrough {
+		return args, nil
+	}
+
+	remain := []string{args[0]}
+	positional := 0
+	given := make(map[string]bool)
+	for index := 1; index < len(args); index++ {
+		found, err := act.matchOptions(args[index])
+		if err != nil {
+			return nil, err
+		}
+
+		if found == nil {
+			if (act.MaxConsume >= 0 && positional >= act.MaxConsume) ||
+				(act.MaxConsume < 0 && act.ConsumeUntil != "" && args[index] == act.ConsumeUntil) {
+				remain = append(remain, args[index:]...)
+				break
+			}
+
+			if args[index] == "--" {
+				remain = append(remain, args[index+1:]...)
+				break
+			}
+
+			if act.UnknownFlagMode != FlagPassthrough && len(args[index]) > 2 && strings.HasPrefix(args[index], "--") {
+				if act.UnknownFlagMode == FlagError {
+					return nil, UnknownFlagError{Path: act.Path(), Flag: args[index]}
+				}
+				continue
+			}
+
+			remain = append(remain, args[index])
+			positional++
+			continue
+		}
+
+		for _, item := range found {
+			opt, value := item.opt, item.value
+			if !item.hasValue {
+				if index+1 >= len(args) {
+					return nil, MissingOptionValueError{Path: act.Path(), Name: opt.Name}
+				}
+				index++
+				value = args[index]
+			}
+
+			if given[opt.Name] && opt.HasValue && !opt.Repeatable {
+				return nil, DuplicateOptionError{Path: act.Path(), Name: opt.Name}
+			}
+			state.setOption(opt.Name, value, given[opt.Name] && opt.Repeatable)
+			given[opt.Name] = true
+		}
+	}
+	return remain, nil
+}
+
+// ParseWithFlags works as Parse(), with option values in flags stored into state before parsing
+// Options given in args take precedence over flags
+func (act Action) ParseWithFlags(state *State, args []string, flags map[string]string, vargs ...interface{}) error {
+	return act.parseWithFlags(state, args, flags, vargs)
+}
+
+// FlagDoc describes an option declared in the Action tree, Path is Path() of the Action declaring it
+type FlagDoc struct {
+	Path       string
+	Name       string
+	Short      string
+	HasValue   bool
+	Repeatable bool
+	Global     bool
+	Descr      string
+}
+
+// AllFlags returns docs of all options declared in the finalized Action tree, including this Action
+// Options are ordered by depth-first traversal in registration order, Default Actions come after SubActions
+// Global options are listed once with the Action declaring them, not with every SubAction accepting them
+func (act *Action) AllFlags() []FlagDoc {
+	docs := []FlagDoc{}
+
+	var walk func(act Action)
+	walk = func(act Action) {
+		if act.injected {
+			return
+		}
+
+		for _, opt := range act.Options {
+			docs = append(docs, FlagDoc{
+				Path:       act.Path(),
+				Name:       opt.Name,
+				Short:      opt.Short,
+				HasValue:   opt.HasValue,
+				Repeatable: opt.Repeatable,
+				Global:     opt.Global,
+				Descr:      opt.Descr,
+			})
+		}
+
+		for _, trigger := range act.SubActions() {
+			walk(act.GetSubAction(trigger))
+		}
+
+		if act.Default != nil {
+			walk(*act.Default)
+		}
+	}
+	walk(*act)
+
+	return docs
+}
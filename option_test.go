@@ -0,0 +1,487 @@
+package argo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOptionBool(t *testing.T) {
+	var verbose, set bool
+	var args []string
+	root := Action{
+		Trigger:    "cmd",
+		MaxConsume: 1,
+		Options:    []Option{{Name: "verbose", Descr: "Print more"}},
+		Do: func(state *State, _ ...interface{}) error {
+			verbose, set = state.OptionBool("verbose")
+			args = state.Args()
+			return nil
+		},
+	}
+	checkEq(t, root.Finalize(), nil)
+
+	checkEq(t, root.Parse(&State{}, []string{"cmd", "--verbose", "a"}), nil)
+	checkEq(t, verbose, true)
+	checkEq(t, set, true)
+	checkEq(t, args, []string{"a"})
+
+	checkEq(t, root.Parse(&State{}, []string{"cmd", "a", "--no-verbose"}), nil)
+	checkEq(t, verbose, false)
+	checkEq(t, set, true)
+	checkEq(t, args, []string{"a"})
+
+	checkEq(t, root.Parse(&State{}, []string{"cmd", "--no-verbose", "--verbose"}), nil)
+	checkEq(t, verbose, true)
+	checkEq(t, set, true)
+	checkEq(t, args, []string{})
+
+	checkEq(t, root.Parse(&State{}, []string{"cmd", "--verbose", "--no-verbose"}), nil)
+	checkEq(t, verbose, false)
+	checkEq(t, set, true)
+
+	checkEq(t, root.Parse(&State{}, []string{"cmd", "a"}), nil)
+	checkEq(t, verbose, false)
+	checkEq(t, set, false)
+}
+
+func TestOptionValue(t *testing.T) {
+	output := ""
+	root := Action{
+		Trigger: "cmd",
+		Options: []Option{{Name: "output", HasValue: true, Descr: "Output file"}},
+	}
+	root.AddSubAction(Action{
+		Trigger:    "build",
+		MinConsume: 1,
+		MaxConsume: 1,
+		Do: func(state *State, _ ...interface{}) error {
+			output, _ = state.Option("output")
+			output += ":" + strings.Join(state.Args(), ",")
+			return nil
+		},
+	})
+	checkEq(t, root.Finalize(), nil)
+
+	checkEq(t, root.Parse(&State{}, []string{"cmd", "--output", "out.txt", "build", "x"}), nil)
+	checkEq(t, output, "out.txt:x")
+
+	checkEq(t, root.Parse(&State{}, []string{"cmd", "--output=a=b", "build", "x"}), nil)
+	checkEq(t, output, "a=b:x")
+
+	// Options of parent are not taken by SubActions
+	err := root.Parse(&State{}, []string{"cmd", "build", "--output", "x"})
+	checkEq(t, err, nil)
+	checkEq(t, output, ":--output")
+
+	err = root.Parse(&State{}, []string{"cmd", "--output"})
+	checkEq(t, err, MissingOptionValueError{Path: "cmd", Name: "output"})
+}
+
+func TestOptionHelp(t *testing.T) {
+	root := Action{
+		Trigger:    "cmd",
+		MaxConsume: 1,
+		Options: []Option{
+			{Name: "verbose", Descr: "Print more"},
+			{Name: "output", HasValue: true, Descr: "Output file"},
+		},
+		DisableHelp: true,
+	}
+	checkEq(t, root.Finalize(), nil)
+
+	checkEq(t, root.Help(), `[Usage]
+cmd [options] [arg1]
+
+[Options]
+--[no-]verbose
+- Print more
+--output <value>
+- Output file`)
+}
+
+func TestOptionRepeatable(t *testing.T) {
+	var tags []string
+	root := Action{
+		Trigger:    "cmd",
+		MaxConsume: -1,
+		Options: []Option{
+			{Name: "tag", HasValue: true, Repeatable: true},
+			{Name: "output", HasValue: true},
+		},
+		Do: func(state *State, _ ...interface{}) error {
+			tags = state.OptionSlice("tag")
+			return nil
+		},
+	}
+	checkEq(t, root.Finalize(), nil)
+
+	state := State{}
+	checkEq(t, root.Parse(&state, []string{"cmd", "--tag", "a", "x", "--tag=b"}), nil)
+	checkEq(t, tags, []string{"a", "b"})
+	value, _ := state.Option("tag")
+	checkEq(t, value, "b")
+
+	// Values from a previous Parse() call are replaced
+	checkEq(t, root.Parse(&state, []string{"cmd", "--tag", "c"}), nil)
+	checkEq(t, tags, []string{"c"})
+
+	checkEq(t, root.Parse(&State{}, []string{"cmd", "x"}), nil)
+	checkEq(t, tags, []string{})
+
+	err := root.Parse(&State{}, []string{"cmd", "--output", "a", "--output", "b"})
+	checkEq(t, err, DuplicateOptionError{Path: "cmd", Name: "output"})
+}
+
+func TestParseWithFlags(t *testing.T) {
+	var output, mode string
+	var verbose bool
+	root := Action{
+		Trigger: "cmd",
+		Options: []Option{
+			{Name: "output", HasValue: true},
+			{Name: "verbose"},
+		},
+		Do: func(state *State, _ ...interface{}) error {
+			output, _ = state.Option("output")
+			mode, _ = state.Option("mode")
+			verbose, _ = state.OptionBool("verbose")
+			return nil
+		},
+	}
+	checkEq(t, root.Finalize(), nil)
+
+	flags := map[string]string{"output": "flag.txt", "mode": "fast", "verbose": "true"}
+	checkEq(t, root.ParseWithFlags(&State{}, []string{"cmd"}, flags), nil)
+	checkEq(t, output, "flag.txt")
+	checkEq(t, mode, "fast")
+	checkEq(t, verbose, true)
+
+	checkEq(t, root.ParseWithFlags(&State{}, []string{"cmd", "--output", "arg.txt", "--no-verbose"}, flags), nil)
+	checkEq(t, output, "arg.txt")
+	checkEq(t, mode, "fast")
+	checkEq(t, verbose, false)
+
+	checkEq(t, root.ParseWithFlags(nil, []string{"cmd"}, flags), NilStateError{})
+}
+
+func TestOptionShort(t *testing.T) {
+	var all, verbose, force bool
+	var output string
+	var args []string
+	root := Action{
+		Trigger:    "cmd",
+		MaxConsume: 1,
+		Options: []Option{
+			{Name: "all", Short: "a"},
+			{Name: "verbose", Short: "v"},
+			{Name: "force", Short: "f"},
+			{Name: "output", Short: "o", HasValue: true},
+		},
+		Do: func(state *State, _ ...interface{}) error {
+			all, _ = state.OptionBool("all")
+			verbose, _ = state.OptionBool("verbose")
+			force, _ = state.OptionBool("force")
+			output, _ = state.Option("output")
+			args = state.Args()
+			return nil
+		},
+		DisableHelp: true,
+	}
+	checkEq(t, root.Finalize(), nil)
+
+	checkEq(t, root.Parse(&State{}, []string{"cmd", "-v", "-o", "out", "x"}), nil)
+	checkEq(t, verbose, true)
+	checkEq(t, output, "out")
+	checkEq(t, args, []string{"x"})
+
+	checkEq(t, root.Parse(&State{}, []string{"cmd", "--verbose", "--output", "out2"}), nil)
+	checkEq(t, verbose, true)
+	checkEq(t, output, "out2")
+
+	checkEq(t, root.Parse(&State{}, []string{"cmd", "-avf"}), nil)
+	checkEq(t, []bool{all, verbose, force}, []bool{true, true, true})
+
+	checkEq(t, root.Parse(&State{}, []string{"cmd", "-fa", "-5"}), nil)
+	checkEq(t, []bool{all, verbose, force}, []bool{true, false, true})
+	checkEq(t, args, []string{"-5"})
+
+	err := root.Parse(&State{}, []string{"cmd", "-vo", "out"})
+	checkEq(t, err, BundledValueOptionError{Path: "cmd", Arg: "-vo", Name: "output"})
+
+	checkEq(t, strings.Contains(root.Help(), "\n-a, --[no-]all\n"), true)
+	checkEq(t, strings.Contains(root.Help(), "\n-o, --output <value>\n"), true)
+
+	invalid := Action{Trigger: "cmd", Options: []Option{{Name: "verbose", Short: "vv"}}}
+	err = invalid.Finalize()
+	checkEq(t, err, InvalidShortOptionError{Path: "cmd", Name: "verbose", Short: "vv"})
+	checkEq(t, err.Error(), `Short alias "vv" of option --verbose is not a single character in Action: cmd`)
+
+	unicode := Action{Trigger: "cmd", Options: []Option{{Name: "lambda", Short: "λ"}}}
+	checkEq(t, unicode.Finalize(), nil)
+}
+
+func TestOptionGlobal(t *testing.T) {
+	var verbose, force bool
+	root := Action{
+		Trigger: "cmd",
+		Options: []Option{
+			{Name: "verbose", Short: "v", Global: true, Descr: "Print details"},
+			{Name: "config", HasValue: true, Descr: "Config file"},
+		},
+	}
+	remote := Action{Trigger: "remote"}
+	remote.AddSubAction(Action{
+		Trigger: "remove",
+		Options: []Option{{Name: "force", Descr: "Remove anyway"}},
+		Do: func(state *State, _ ...interface{}) error {
+			verbose, _ = state.OptionBool("verbose")
+			force, _ = state.OptionBool("force")
+			return nil
+		},
+	})
+	root.AddSubAction(remote)
+	checkEq(t, root.Finalize(), nil)
+
+	checkEq(t, root.Parse(&State{}, []string{"cmd", "remote", "remove", "-v", "--force"}), nil)
+	checkEq(t, []bool{verbose, force}, []bool{true, true})
+
+	remove := root.GetSubAction("remote").GetSubAction("remove")
+	checkEq(t, remove.Help(), `[Usage]
+cmd remote remove [options] [sub-action]
+
+[Options]
+--[no-]force
+- Remove anyway
+
+[Global Options]
+-v, --[no-]verbose
+- Print details
+
+[Sub-actions]
+help
+- Display help for commands`)
+
+	checkEq(t, strings.Contains(root.Help(), "[Global Options]"), false)
+	checkEq(t, strings.Contains(remove.Help(), "config"), false)
+}
+
+func TestUnknownFlagMode(t *testing.T) {
+	var args []string
+	newRoot := func(mode UnknownFlagMode) *Action {
+		root := &Action{Trigger: "wrap", UnknownFlagMode: mode}
+		root.AddSubAction(Action{
+			Trigger:    "run",
+			MaxConsume: -1,
+			Options:    []Option{{Name: "dry"}},
+			Do: func(state *State, _ ...interface{}) error {
+				args = state.Args()
+				return nil
+			},
+		})
+		checkEq(t, root.Finalize(), nil)
+		return root
+	}
+
+	root := newRoot(FlagPassthrough)
+	checkEq(t, root.Parse(&State{}, []string{"wrap", "run", "--foo", "x", "--dry"}), nil)
+	checkEq(t, args, []string{"--foo", "x"})
+
+	root = newRoot(FlagIgnore)
+	checkEq(t, root.Parse(&State{}, []string{"wrap", "run", "--foo", "x", "--", "--dry"}), nil)
+	checkEq(t, args, []string{"x", "--dry"})
+
+	root = newRoot(FlagError)
+	err := root.Parse(&State{}, []string{"wrap", "run", "x", "--foo"})
+	checkEq(t, err, UnknownFlagError{Path: "wrap run", Flag: "--foo"})
+	checkEq(t, err.Error(), "Parsing Error: Unknown option --foo\nActionPath: wrap run")
+	checkEq(t, root.Parse(&State{}, []string{"wrap", "run", "x", "-5"}), nil)
+	checkEq(t, args, []string{"x", "-5"})
+
+	// A SubAction can opt back into FlagPassthrough
+	root = newRoot(FlagError)
+	checkEq(t, root.AddSubAction(Action{
+		Trigger:         "raw",
+		MaxConsume:      -1,
+		UnknownFlagMode: FlagPassthrough,
+		Do: func(state *State, _ ...interface{}) error {
+			args = state.Args()
+			return nil
+		},
+	}), nil)
+	checkEq(t, root.Refinalize(), nil)
+	checkEq(t, root.Parse(&State{}, []string{"wrap", "raw", "--x"}), nil)
+	checkEq(t, args, []string{"--x"})
+}
+
+func TestOptionConsumeBounds(t *testing.T) {
+	var args []string
+	var tags []string
+	root := Action{Trigger: "tool", Options: []Option{{Name: "verbose", Short: "v", Global: true}}}
+	root.AddSubAction(Action{
+		Trigger:    "cp",
+		MinConsume: 2,
+		MaxConsume: 3,
+		Options: []Option{
+			{Name: "tag", Short: "t", HasValue: true, Repeatable: true},
+			{Name: "force", Short: "f"},
+		},
+		Do: func(state *State, _ ...interface{}) error {
+			args = state.Args()
+			tags = state.OptionSlice("tag")
+			return nil
+		},
+		DisableHelp: true,
+	})
+	checkEq(t, root.Finalize(), nil)
+
+	// Option tokens and their values are not counted towards MinConsume
+	err := root.Parse(&State{}, []string{"tool", "cp", "--tag", "a", "src", "-t", "b", "-vf"})
+	checkTypeEq(t, err, TooFewArgsError{})
+
+	checkEq(t, root.Parse(&State{}, []string{"tool", "cp", "--tag", "a", "src", "-vf", "-t", "b", "dst"}), nil)
+	checkEq(t, args, []string{"src", "dst"})
+	checkEq(t, tags, []string{"a", "b"})
+
+	// Nor towards MaxConsume, the positional args beyond it are left unconsumed
+	checkEq(t, root.Parse(&State{}, []string{"tool", "cp", "a", "--force", "b", "--tag=x", "c", "-v", "d"}), nil)
+	checkEq(t, args, []string{"a", "b", "c"})
+	checkEq(t, tags, []string{"x"})
+}
+
+func TestOptionsEnd(t *testing.T) {
+	var args, subArgs []string
+	var force bool
+	root := Action{
+		Trigger:      "tool",
+		MaxConsume:   -1,
+		ConsumeUntil: "+",
+		Options:      []Option{{Name: "force", Short: "f"}},
+		Do: func(state *State, _ ...interface{}) error {
+			args = state.Args()
+			force, _ = state.OptionBool("force")
+			return nil
+		},
+	}
+	root.AddSubAction(Action{
+		Trigger:    "then",
+		MaxConsume: -1,
+		Do: func(state *State, _ ...interface{}) error {
+			subArgs = state.Args()
+			return nil
+		},
+	})
+	checkEq(t, root.Finalize(), nil)
+
+	// `--` ends options and is dropped
+	checkEq(t, root.Parse(&State{}, []string{"tool", "a", "--", "-f", "--force"}), nil)
+	checkEq(t, args, []string{"a", "-f", "--force"})
+	checkEq(t, force, false)
+
+	// Options after ConsumeUntil belong to the SubAction
+	checkEq(t, root.Parse(&State{}, []string{"tool", "a", "+", "then", "--force", "b"}), nil)
+	checkEq(t, args, []string{"a"})
+	checkEq(t, force, false)
+	checkEq(t, subArgs, []string{"--force", "b"})
+
+	// Options are not kept across Parse() calls with the same state
+	state := &State{}
+	checkEq(t, root.Parse(state, []string{"tool", "-f"}), nil)
+	checkEq(t, force, true)
+	checkEq(t, root.Parse(state, []string{"tool"}), nil)
+	checkEq(t, force, false)
+}
+
+func TestTriggerLooksLikeFlag(t *testing.T) {
+	root := Action{Trigger: "cmd", Options: []Option{{Name: "verbose"}}}
+	root.AddSubAction(Action{Trigger: "build"})
+	checkEq(t, root.Finalize(), nil)
+
+	root = Action{Trigger: "cmd", Options: []Option{{Name: "verbose"}}}
+	root.AddSubAction(Action{Trigger: "-x"})
+	err := root.Finalize()
+	checkEq(t, err, TriggerLooksLikeFlagError{Path: "cmd", Trigger: "-x"})
+	checkEq(t, err.Error(), `SubAction "-x" looks like an option of Action: cmd`)
+
+	// Global options of ancestors and UnknownFlagMode enable options as well
+	root = Action{Trigger: "cmd", Options: []Option{{Name: "verbose", Global: true}}}
+	sub := Action{Trigger: "sub"}
+	sub.AddSubAction(Action{Trigger: "-x"})
+	root.AddSubAction(sub)
+	checkEq(t, root.Finalize(), TriggerLooksLikeFlagError{Path: "cmd sub", Trigger: "-x"})
+
+	root = Action{Trigger: "cmd", UnknownFlagMode: FlagError}
+	root.AddSubAction(Action{Trigger: "-x"})
+	checkTypeEq(t, root.Finalize(), TriggerLooksLikeFlagError{})
+
+	// Triggers starting with "-" are allowed without options
+	root = Action{Trigger: "cmd"}
+	root.AddSubAction(Action{Trigger: "-x"})
+	root.AddSubAction(Action{Trigger: "-"})
+	checkEq(t, root.Finalize(), nil)
+}
+
+func TestAllFlags(t *testing.T) {
+	root := Action{
+		Trigger: "tool",
+		Options: []Option{{Name: "verbose", Short: "v", Global: true, Descr: "Verbose output"}},
+	}
+	build := Action{
+		Trigger: "build",
+		Options: []Option{{Name: "output", Short: "o", HasValue: true, Descr: "Output file"}},
+	}
+	build.AddSubAction(Action{Trigger: "all", Options: []Option{{Name: "tag", HasValue: true, Repeatable: true}}})
+	root.AddSubAction(build)
+	root.AddSubAction(Action{Trigger: "clean"})
+	checkEq(t, root.Finalize(), nil)
+
+	checkEq(t, root.AllFlags(), []FlagDoc{
+		{Path: "tool", Name: "verbose", Short: "v", Global: true, Descr: "Verbose output"},
+		{Path: "tool build", Name: "output", Short: "o", HasValue: true, Descr: "Output file"},
+		{Path: "tool build all", Name: "tag", HasValue: true, Repeatable: true},
+	})
+	checkEq(t, root.GetSubActionPtr("clean").AllFlags(), []FlagDoc{})
+}
+
+func TestFlagSet(t *testing.T) {
+	common := FlagSet{
+		{Name: "verbose", Short: "v"},
+		{Name: "config", Short: "c", HasValue: true},
+	}
+
+	var config string
+	var verbose bool
+	record := func(state *State, _ ...interface{}) error {
+		config, _ = state.Option("config")
+		verbose, _ = state.OptionBool("verbose")
+		return nil
+	}
+
+	root := Action{Trigger: "tool"}
+	build := Action{Trigger: "build", Options: []Option{{Name: "output", HasValue: true}}, Do: record}
+	build.AddFlags(common)
+	deploy := Action{Trigger: "deploy", Do: record}
+	deploy.AddFlags(common)
+	root.AddSubAction(build)
+	root.AddSubAction(deploy)
+	checkEq(t, root.Finalize(), nil)
+	checkEq(t, len(common), 2)
+
+	checkEq(t, root.Parse(&State{}, []string{"tool", "build", "-v", "--config", "a.conf"}), nil)
+	checkEq(t, config, "a.conf")
+	checkEq(t, verbose, true)
+
+	checkEq(t, root.Parse(&State{}, []string{"tool", "deploy", "-c", "b.conf"}), nil)
+	checkEq(t, config, "b.conf")
+	checkEq(t, verbose, false)
+
+	// Conflicts with existing options are detected by Finalize()
+	root = Action{Trigger: "tool", Options: []Option{{Name: "config", HasValue: true}}}
+	root.AddFlags(common)
+	err := root.Finalize()
+	checkEq(t, err, OptionConflictError{Path: "tool", Flag: "--config"})
+	checkEq(t, err.Error(), "Option --config is declared more than once in Action: tool")
+
+	root = Action{Trigger: "tool", Options: []Option{{Name: "version", Short: "v"}}}
+	root.AddFlags(common)
+	checkEq(t, root.Finalize(), OptionConflictError{Path: "tool", Flag: "-v"})
+}
@@ -0,0 +1,61 @@
+package argo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const defaultQuitTrigger = "quit"
+
+// REPL reads lines from in and parses each of them as args following the Trigger of this Action
+// Outputs and errors are written to out. It returns when in reaches EOF or a line equal to QuitTrigger is read
+// Prompt is written to out before reading each line
+func (act *Action) REPL(in io.Reader, out io.Writer, vargs ...interface{}) error {
+	if !act.finalized {
+		return ActionNotFinalizedError{Victim: *act}
+	}
+
+	quit := act.QuitTrigger
+	if quit == "" {
+		quit = defaultQuitTrigger
+	}
+
+	scanner := bufio.NewScanner(in)
+	state := &State{}
+	for {
+		io.WriteString(out, act.Prompt)
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+
+		line := scanner.Text()
+		if strings.TrimSpace(line) == quit {
+			return nil
+		}
+
+		statements, err := tokenize(line, act.StatementSeparator)
+		if err != nil {
+			fmt.Fprintln(out, err)
+			continue
+		}
+
+		for _, args := range statements {
+			state.Reset()
+			err := act.Parse(state, append([]string{act.Trigger}, args...), vargs...)
+
+			output := state.OutputStr.String()
+			if output != "" {
+				if !strings.HasSuffix(output, "\n") {
+					output += "\n"
+				}
+				io.WriteString(out, output)
+			}
+
+			if err != nil {
+				fmt.Fprintln(out, err)
+			}
+		}
+	}
+}
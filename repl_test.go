@@ -0,0 +1,49 @@
+package argo
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestREPL(t *testing.T) {
+	act := Action{
+		Trigger: "calc",
+		Prompt:  "> ",
+	}
+	act.AddSubAction(Action{
+		Trigger:    "echo",
+		MaxConsume: -1,
+		Do: func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString(strings.Join(state.Args(), "|"))
+			return nil
+		},
+	})
+	act.AddSubAction(Action{Trigger: "need", MinConsume: 1})
+
+	err := act.REPL(strings.NewReader(""), &bytes.Buffer{})
+	checkTypeEq(t, err, ActionNotFinalizedError{})
+
+	checkEq(t, act.Finalize(), nil)
+	out := &bytes.Buffer{}
+	in := strings.NewReader("echo a \"b c\"\n\nneed\necho d\nquit\necho e\n")
+	checkEq(t, act.REPL(in, out), nil)
+
+	tooFew := TooFewArgsError{Victim: act.GetSubAction("need"), Args: []string{}}
+	checkEq(t, out.String(), "> a|b c\n> > "+tooFew.Error()+"\n> d\n> ")
+}
+
+func TestREPLEOF(t *testing.T) {
+	act := Action{
+		Trigger: "calc",
+		Do: func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString("called")
+			return nil
+		},
+	}
+	checkEq(t, act.Finalize(), nil)
+
+	out := &bytes.Buffer{}
+	checkEq(t, act.REPL(strings.NewReader("\nx"), out), nil)
+	checkEq(t, out.String(), "called\n")
+}
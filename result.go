@@ -0,0 +1,36 @@
+package argo
+
+// Result is the outcome of a ParseResult() call
+type Result struct {
+	// Path of the last triggered Action, empty if nothing is triggered
+	Path string
+
+	// Args consumed by the last triggered Action
+	Args []string
+
+	// Help is true if help text is shown, e.g. the injected help SubAction is triggered
+	Help bool
+}
+
+// ParseResult is the same as Parse(), but also returns the outcome of parsing as Result
+func (act Action) ParseResult(state *State, args []string, vargs ...interface{}) (Result, error) {
+	if state != nil {
+		state.path = ""
+		state.doArgs = nil
+		state.helpShown = false
+	}
+
+	if err := act.Parse(state, args, vargs...); err != nil {
+		return Result{}, err
+	}
+
+	if state == nil {
+		return Result{}, nil
+	}
+
+	return Result{
+		Path: state.path,
+		Args: state.doArgs,
+		Help: state.helpShown,
+	}, nil
+}
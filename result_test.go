@@ -0,0 +1,30 @@
+package argo
+
+import "testing"
+
+func TestParseResult(t *testing.T) {
+	root := Action{Trigger: "root", MaxConsume: 1}
+	sub := Action{Trigger: "sub"}
+	sub.AddSubAction(Action{Trigger: "leaf", MaxConsume: -1})
+	root.AddSubAction(sub)
+	checkEq(t, root.Finalize(), nil)
+
+	state := &State{}
+	result, err := root.ParseResult(state, []string{"root", "arg"})
+	checkEq(t, err, nil)
+	checkEq(t, result, Result{Path: "root", Args: []string{"arg"}})
+
+	result, err = root.ParseResult(state, []string{"root", "arg", "sub", "leaf", "a", "b"})
+	checkEq(t, err, nil)
+	checkEq(t, result, Result{Path: "root sub leaf", Args: []string{"a", "b"}})
+
+	state = &State{}
+	result, err = root.ParseResult(state, []string{"root", "arg", "sub", "help"})
+	checkEq(t, err, nil)
+	checkEq(t, result, Result{Path: "root sub help", Args: []string{}, Help: true})
+	checkEq(t, state.OutputStr.String(), root.GetSubActionPtr("sub").Help())
+
+	result, err = root.ParseResult(state, []string{"other"})
+	checkEq(t, err, nil)
+	checkEq(t, result, Result{})
+}
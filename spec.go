@@ -0,0 +1,79 @@
+package argo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TreeSpec declares the expected structure of an Action tree
+type TreeSpec struct {
+	Trigger    string
+	MinConsume int
+	MaxConsume int
+	SubActions []TreeSpec
+}
+
+// SpecMismatchError indicates an Action tree does not match a TreeSpec
+// Each item of Mismatches describes one difference
+type SpecMismatchError struct {
+	Err
+	Mismatches []string
+}
+
+func (e SpecMismatchError) Error() string {
+	return "Action tree does not match spec:\n" + strings.Join(e.Mismatches, "\n")
+}
+
+func (SpecMismatchError) Code() string {
+	return "argo.spec_mismatch"
+}
+
+// ValidateAgainst compares the finalized Action tree with spec, and returns SpecMismatchError listing all differences
+// Triggers, MinConsume, MaxConsume and SubActions are compared, injected help SubActions are ignored
+func (act *Action) ValidateAgainst(spec TreeSpec) error {
+	if !act.finalized {
+		return ActionNotFinalizedError{Victim: *act}
+	}
+
+	mismatches := []string{}
+	var validate func(act *Action, spec TreeSpec)
+	validate = func(act *Action, spec TreeSpec) {
+		if act.Trigger != spec.Trigger {
+			mismatches = append(mismatches,
+				fmt.Sprintf("%s: Trigger is %q, expected %q", act.Path(), act.Trigger, spec.Trigger))
+		}
+		if act.MinConsume != spec.MinConsume {
+			mismatches = append(mismatches,
+				fmt.Sprintf("%s: MinConsume is %d, expected %d", act.Path(), act.MinConsume, spec.MinConsume))
+		}
+		if act.MaxConsume != spec.MaxConsume {
+			mismatches = append(mismatches,
+				fmt.Sprintf("%s: MaxConsume is %d, expected %d", act.Path(), act.MaxConsume, spec.MaxConsume))
+		}
+
+		expected := make(map[string]bool)
+		for _, subSpec := range spec.SubActions {
+			expected[subSpec.Trigger] = true
+			subAct := act.subActionLookup[subSpec.Trigger]
+			if subAct == nil || subAct.injected {
+				mismatches = append(mismatches,
+					fmt.Sprintf("%s: SubAction %q is missing", act.Path(), subSpec.Trigger))
+				continue
+			}
+			validate(subAct, subSpec)
+		}
+
+		for _, trigger := range act.subActionTrigger {
+			if !expected[trigger] && !act.subActionLookup[trigger].injected {
+				mismatches = append(mismatches,
+					fmt.Sprintf("%s: SubAction %q is not expected", act.Path(), trigger))
+			}
+		}
+	}
+	validate(act, spec)
+
+	if len(mismatches) > 0 {
+		return SpecMismatchError{Mismatches: mismatches}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package argo
+
+import "testing"
+
+func TestValidateAgainst(t *testing.T) {
+	root := Action{Trigger: "root"}
+	file := Action{Trigger: "file"}
+	file.AddSubAction(Action{Trigger: "cp", MinConsume: 2, MaxConsume: 2})
+	root.AddSubAction(file)
+	root.AddSubAction(Action{Trigger: "ls", MaxConsume: -1})
+	checkEq(t, root.Finalize(), nil)
+
+	spec := TreeSpec{
+		Trigger: "root",
+		SubActions: []TreeSpec{
+			{Trigger: "file", SubActions: []TreeSpec{{Trigger: "cp", MinConsume: 2, MaxConsume: 2}}},
+			{Trigger: "ls", MaxConsume: -1},
+		},
+	}
+	checkEq(t, root.ValidateAgainst(spec), nil)
+
+	spec.SubActions[0].SubActions[0].MaxConsume = 3
+	spec.SubActions[1] = TreeSpec{Trigger: "rm"}
+	err := root.ValidateAgainst(spec)
+	checkEq(t, err, SpecMismatchError{Mismatches: []string{
+		`root file cp: MaxConsume is 2, expected 3`,
+		`root: SubAction "rm" is missing`,
+		`root: SubAction "ls" is not expected`,
+	}})
+
+	unfinalized := Action{Trigger: "root"}
+	checkTypeEq(t, unfinalized.ValidateAgainst(TreeSpec{Trigger: "root"}), ActionNotFinalizedError{})
+}
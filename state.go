@@ -1,12 +1,46 @@
 package argo
 
-import "strings"
+import (
+	"strconv"
+	"strings"
+	"time"
+)
 
 // State keeps the state withing a argument parsing call
 type State struct {
 	// String reply after arguments are parsed
 	OutputStr strings.Builder
-	doArgs    []string
+
+	// DisabledCommands disables Actions by Path() in Parse() calls with this State
+	// Disabled Actions are not triggered as if they do not exist, and are hidden in help text
+	// It is not cleared by Reset()
+	DisabledCommands map[string]bool
+
+	doArgs        []string
+	argNames      []string
+	sensitiveArgs []bool
+	path          string
+	results       map[string]interface{}
+
+	assignments map[string]string
+	timings     []LevelTiming
+	values      map[string]interface{}
+	captures    map[string]string
+	helpShown   bool
+	chained     bool
+	vargs       []interface{}
+	options     map[string][]string
+	dryRun      bool
+	dryPath     []string
+
+	consumedCount int
+}
+
+// LevelTiming is the time spent on a triggered Action during Parse()
+// It covers consuming args and the Do() call, but not the following SubActions
+type LevelTiming struct {
+	Path     string
+	Duration time.Duration
 }
 
 // Args returns arguments consumed by triggering Action
@@ -14,3 +48,251 @@ type State struct {
 func (s *State) Args() []string {
 	return s.doArgs
 }
+
+// ConsumedCount returns the number of args taken from the command line by triggering Action
+// Args filled from ArgEnv or returned by PromptFunc are not counted
+// This function is only valid inside a Action.Do() call
+func (s *State) ConsumedCount() int {
+	return s.consumedCount
+}
+
+// SuppliedCount returns the number of args passed to triggering Action,
+// including args filled from ArgEnv or returned by PromptFunc
+// This function is only valid inside a Action.Do() call
+func (s *State) SuppliedCount() int {
+	return len(s.doArgs)
+}
+
+// NamedArg returns the consumed arg named name in ArgNames of triggering Action
+// ok is false if name is not in ArgNames, or the arg is not supplied
+// This function is only valid inside a Action.Do() call
+func (s *State) NamedArg(name string) (value string, ok bool) {
+	for index, argName := range s.argNames {
+		if argName == name && index < len(s.doArgs) {
+			return s.doArgs[index], true
+		}
+	}
+	return "", false
+}
+
+// ArgsInt returns arguments consumed by triggering Action converted into ints, which are parsed as decimal
+// ArgConversionError is returned for the first arg which is not an int
+// This function is only valid inside a Action.Do() call
+func (s *State) ArgsInt() ([]int, error) {
+	values := make([]int, 0, len(s.doArgs))
+	for index, arg := range s.doArgs {
+		v, err := strconv.ParseInt(arg, 10, strconv.IntSize)
+		if err != nil {
+			return nil, ArgConversionError{Path: s.path, Index: index, Value: redactArg(s.sensitiveArgs, index, arg), Type: "int"}
+		}
+		values = append(values, int(v))
+	}
+	return values, nil
+}
+
+// ArgsFloat returns arguments consumed by triggering Action converted into float64s
+// ArgConversionError is returned for the first arg which is not a float64
+// This function is only valid inside a Action.Do() call
+func (s *State) ArgsFloat() ([]float64, error) {
+	values := make([]float64, 0, len(s.doArgs))
+	for index, arg := range s.doArgs {
+		v, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, ArgConversionError{Path: s.path, Index: index, Value: redactArg(s.sensitiveArgs, index, arg), Type: "float64"}
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// Reset clears all data in State so it can be reused for another Parse() call
+// Reset must not be called while a Action.Do() call is still reading from the State
+func (s *State) Reset() {
+	s.OutputStr.Reset()
+	s.doArgs = nil
+	s.argNames = nil
+	s.sensitiveArgs = nil
+	s.consumedCount = 0
+	s.path = ""
+	s.results = nil
+	s.assignments = nil
+	s.timings = nil
+	s.values = nil
+	s.captures = nil
+	s.helpShown = false
+	s.chained = false
+	s.vargs = nil
+	s.options = nil
+}
+
+// SetResult stores v as the result of triggering Action, keyed by the Action's Path()
+// This function is only valid inside a Action.Do() call
+func (s *State) SetResult(v interface{}) {
+	s.SetResultFor(s.path, v)
+}
+
+// SetResultFor stores v as the result of the Action with Path() `path`
+func (s *State) SetResultFor(path string, v interface{}) {
+	if s.results == nil {
+		s.results = make(map[string]interface{})
+	}
+	s.results[path] = v
+}
+
+// Results returns all results stored by SetResult() and SetResultFor(), keyed by Action path
+func (s *State) Results() map[string]interface{} {
+	return s.results
+}
+
+// Assignments returns leading KEY=VALUE args parsed when Action.LeadingAssignments is enabled
+func (s *State) Assignments() map[string]string {
+	return s.assignments
+}
+
+// takeAssignments stores leading KEY=VALUE args and returns the remaining args
+func (s *State) takeAssignments(args []string) []string {
+	for len(args) > 0 {
+		index := strings.Index(args[0], "=")
+		if index <= 0 || !isAssignmentName(args[0][:index]) {
+			break
+		}
+
+		if s.assignments == nil {
+			s.assignments = make(map[string]string)
+		}
+		s.assignments[args[0][:index]] = args[0][index+1:]
+		args = args[1:]
+	}
+	return args
+}
+
+// isAssignmentName checks if name is a valid shell variable name
+func isAssignmentName(name string) bool {
+	for index, char := range name {
+		switch {
+		case char == '_', 'a' <= char && char <= 'z', 'A' <= char && char <= 'Z':
+		case index > 0 && '0' <= char && char <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Timings returns time spent on each triggered level, in triggering order
+// Timings are only collected when Action.CollectTimings is enabled
+func (s *State) Timings() []LevelTiming {
+	return s.timings
+}
+
+// Set stores a structured output value with key, overwriting the previous value of the same key
+func (s *State) Set(key string, value interface{}) {
+	if s.values == nil {
+		s.values = make(map[string]interface{})
+	}
+	s.values[key] = value
+}
+
+// Get retrieves a value stored by Set(), ok is false if key is not set
+func (s *State) Get(key string) (value interface{}, ok bool) {
+	value, ok = s.values[key]
+	return
+}
+
+// Capture returns the arg captured by a Capture Action with name, ok is false if nothing is captured
+func (s *State) Capture(name string) (value string, ok bool) {
+	value, ok = s.captures[name]
+	return
+}
+
+func (s *State) setCapture(name string, value string) {
+	if s.captures == nil {
+		s.captures = make(map[string]string)
+	}
+	s.captures[name] = value
+}
+
+// Option returns the value of option name given in args, ok is false if the option is not given
+// Boolean options have value "true" or "false"
+// If the option is given multiple times, the last value is returned
+func (s *State) Option(name string) (value string, ok bool) {
+	values, ok := s.options[name]
+	if ok {
+		value = values[len(values)-1]
+	}
+	return
+}
+
+// OptionSlice returns all values of option name given in args in order
+// An empty slice is returned if the option is not given
+func (s *State) OptionSlice(name string) []string {
+	return append([]string{}, s.options[name]...)
+}
+
+// OptionBool returns the value of boolean option name, set is false if the option is not given
+// If the option is given multiple times, the last one wins
+func (s *State) OptionBool(name string) (value bool, set bool) {
+	str, set := s.Option(name)
+	value, _ = strconv.ParseBool(str)
+	return
+}
+
+// setOption stores value of option name, it is appended to the previous values if appendValue is true
+func (s *State) setOption(name string, value string, appendValue bool) {
+	if s.options == nil {
+		s.options = make(map[string][]string)
+	}
+
+	if appendValue {
+		s.options[name] = append(s.options[name], value)
+	} else {
+		s.options[name] = []string{value}
+	}
+}
+
+// Varg returns the vargs passed to Parse() at index, ok is false if index is out of range
+func (s *State) Varg(index int) (value interface{}, ok bool) {
+	if index < 0 || index >= len(s.vargs) {
+		return nil, false
+	}
+	return s.vargs[index], true
+}
+
+// VargAs returns the vargs passed to Parse() at index as type T
+// ok is false if index is out of range or the value is not a T
+func VargAs[T any](s *State, index int) (value T, ok bool) {
+	v, ok := s.Varg(index)
+	if !ok {
+		return
+	}
+	value, ok = v.(T)
+	return
+}
+
+// chainOutput inserts sep before output written since outputLen,
+// if any output is written by previous Actions in the same Parse() call
+func (s *State) chainOutput(outputLen int, sep string) {
+	if s.OutputStr.Len() == outputLen {
+		return
+	}
+
+	if sep != "" && s.chained {
+		output := s.OutputStr.String()
+		s.OutputStr.Reset()
+		s.OutputStr.WriteString(output[:outputLen])
+		s.OutputStr.WriteString(sep)
+		s.OutputStr.WriteString(output[outputLen:])
+	}
+	s.chained = true
+}
+
+// truncateOutput discards output written since outputLen
+func (s *State) truncateOutput(outputLen int) {
+	if s.OutputStr.Len() == outputLen {
+		return
+	}
+
+	output := s.OutputStr.String()
+	s.OutputStr.Reset()
+	s.OutputStr.WriteString(output[:outputLen])
+}
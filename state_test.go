@@ -0,0 +1,369 @@
+package argo
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResults(t *testing.T) {
+	root := Action{
+		Trigger:    "root",
+		MaxConsume: 1,
+		Do: func(state *State, _ ...interface{}) error {
+			state.SetResult(state.Args()[0])
+			return nil
+		},
+	}
+
+	root.AddSubAction(Action{
+		Trigger: "sub",
+		Do: func(state *State, _ ...interface{}) error {
+			state.SetResult(42)
+			return nil
+		},
+	})
+
+	root.Finalize()
+	state := &State{}
+	err := root.Parse(state, []string{"root", "val", "sub"})
+	checkEq(t, err, nil)
+	checkEq(t, state.Results(), map[string]interface{}{
+		"root":     "val",
+		"root sub": 42,
+	})
+}
+
+func TestSetResultFor(t *testing.T) {
+	state := &State{}
+	checkEq(t, len(state.Results()), 0)
+	state.SetResultFor("a b", true)
+	checkEq(t, state.Results()["a b"], true)
+}
+
+func TestStateReset(t *testing.T) {
+	act := Action{
+		Trigger:    "test",
+		MaxConsume: -1,
+		Do: func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString(state.Args()[0])
+			state.SetResult(state.Args()[0])
+			return nil
+		},
+	}
+	act.Finalize()
+
+	pool := sync.Pool{
+		New: func() interface{} {
+			return &State{}
+		},
+	}
+
+	state := pool.Get().(*State)
+	checkEq(t, act.Parse(state, []string{"test", "first"}), nil)
+	checkEq(t, state.OutputStr.String(), "first")
+	state.Reset()
+	pool.Put(state)
+
+	state = pool.Get().(*State)
+	checkEq(t, state.OutputStr.String(), "")
+	checkEq(t, len(state.Args()), 0)
+	checkEq(t, len(state.Results()), 0)
+	checkEq(t, act.Parse(state, []string{"test", "second"}), nil)
+	checkEq(t, state.OutputStr.String(), "second")
+	checkEq(t, state.Results(), map[string]interface{}{"test": "second"})
+}
+
+func TestLeadingAssignments(t *testing.T) {
+	root := Action{
+		Trigger:            "cmd",
+		LeadingAssignments: true,
+	}
+
+	root.AddSubAction(Action{
+		Trigger:    "sub",
+		MaxConsume: 1,
+		Do: func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString(state.Assignments()["FOO"] + state.Args()[0])
+			return nil
+		},
+	})
+
+	root.Finalize()
+	state := &State{}
+	err := root.Parse(state, []string{"FOO=bar", "_X1=", "cmd", "sub", "A=b"})
+	checkEq(t, err, nil)
+	checkEq(t, state.OutputStr.String(), "barA=b")
+	checkEq(t, state.Assignments(), map[string]string{"FOO": "bar", "_X1": ""})
+
+	state = &State{}
+	err = root.Parse(state, []string{"1A=bar", "cmd", "sub", "x"})
+	checkEq(t, err, nil)
+	checkEq(t, state.OutputStr.String(), "")
+	checkEq(t, len(state.Assignments()), 0)
+}
+
+func TestTimings(t *testing.T) {
+	root := Action{
+		Trigger:        "root",
+		CollectTimings: true,
+	}
+
+	sub := Action{Trigger: "sub"}
+	sub.AddSubAction(Action{
+		Trigger: "leaf",
+		Do: func(_ *State, _ ...interface{}) error {
+			time.Sleep(time.Millisecond)
+			return nil
+		},
+	})
+	root.AddSubAction(sub)
+	root.Finalize()
+
+	state := &State{}
+	checkEq(t, root.Parse(state, []string{"root", "sub", "leaf"}), nil)
+	timings := state.Timings()
+	checkEq(t, len(timings), 3)
+	checkEq(t, timings[0].Path, "root")
+	checkEq(t, timings[1].Path, "root sub")
+	checkEq(t, timings[2].Path, "root sub leaf")
+	checkEq(t, timings[2].Duration >= time.Millisecond, true)
+
+	root2 := Action{Trigger: "root"}
+	root2.Finalize()
+	state = &State{}
+	checkEq(t, root2.Parse(state, []string{"root"}), nil)
+	checkEq(t, len(state.Timings()), 0)
+}
+
+func TestStateSetGet(t *testing.T) {
+	state := &State{}
+	_, ok := state.Get("missing")
+	checkEq(t, ok, false)
+
+	act := Action{
+		Trigger: "test",
+		Do: func(state *State, _ ...interface{}) error {
+			state.Set("count", 1)
+			state.Set("name", "argo")
+			state.Set("count", 2)
+			return nil
+		},
+	}
+	act.Finalize()
+	checkEq(t, act.Parse(state, []string{"test"}), nil)
+
+	value, ok := state.Get("count")
+	checkEq(t, ok, true)
+	checkEq(t, value, 2)
+	value, ok = state.Get("name")
+	checkEq(t, ok, true)
+	checkEq(t, value, "argo")
+	_, ok = state.Get("missing")
+	checkEq(t, ok, false)
+
+	state.Reset()
+	_, ok = state.Get("name")
+	checkEq(t, ok, false)
+}
+
+func TestStateVarg(t *testing.T) {
+	var (
+		number    int
+		numberOk  bool
+		wrongOk   bool
+		missingOk bool
+	)
+	root := Action{
+		Trigger: "root",
+		Do: func(state *State, _ ...interface{}) error {
+			number, numberOk = VargAs[int](state, 0)
+			_, wrongOk = VargAs[string](state, 0)
+			_, missingOk = VargAs[int](state, 1)
+			return nil
+		},
+	}
+	checkEq(t, root.Finalize(), nil)
+
+	state := State{}
+	checkEq(t, root.Parse(&state, []string{"root"}, 9527), nil)
+	checkEq(t, number, 9527)
+	checkEq(t, numberOk, true)
+	checkEq(t, wrongOk, false)
+	checkEq(t, missingOk, false)
+
+	value, ok := state.Varg(0)
+	checkEq(t, value, 9527)
+	checkEq(t, ok, true)
+	_, ok = state.Varg(-1)
+	checkEq(t, ok, false)
+
+	state.Reset()
+	_, ok = state.Varg(0)
+	checkEq(t, ok, false)
+}
+
+func TestArgsInt(t *testing.T) {
+	var ints []int
+	var floats []float64
+	root := Action{
+		Trigger:    "sum",
+		MaxConsume: -1,
+		Do: func(state *State, _ ...interface{}) error {
+			var err error
+			if ints, err = state.ArgsInt(); err != nil {
+				return err
+			}
+			floats, err = state.ArgsFloat()
+			return err
+		},
+	}
+	checkEq(t, root.Finalize(), nil)
+
+	checkEq(t, root.Parse(&State{}, []string{"sum", "1", "2", "3"}), nil)
+	checkEq(t, ints, []int{1, 2, 3})
+	checkEq(t, floats, []float64{1, 2, 3})
+
+	err := root.Parse(&State{}, []string{"sum", "1", "x", "3"})
+	checkEq(t, err, ArgConversionError{Path: "sum", Index: 1, Value: "x", Type: "int"})
+
+	// Args are decimal, without base prefixes or underscores
+	checkEq(t, root.Parse(&State{}, []string{"sum", "010", "-07"}), nil)
+	checkEq(t, ints, []int{10, -7})
+
+	err = root.Parse(&State{}, []string{"sum", "0x10"})
+	checkEq(t, err, ArgConversionError{Path: "sum", Index: 0, Value: "0x10", Type: "int"})
+
+	err = root.Parse(&State{}, []string{"sum", "1_000"})
+	checkEq(t, err, ArgConversionError{Path: "sum", Index: 0, Value: "1_000", Type: "int"})
+
+	checkEq(t, root.Parse(&State{}, []string{"sum"}), nil)
+	checkEq(t, ints, []int{})
+	checkEq(t, floats, []float64{})
+}
+
+func TestArgsFloat(t *testing.T) {
+	var floats []float64
+	root := Action{
+		Trigger:    "sum",
+		MaxConsume: -1,
+		Do: func(state *State, _ ...interface{}) error {
+			var err error
+			floats, err = state.ArgsFloat()
+			return err
+		},
+	}
+	checkEq(t, root.Finalize(), nil)
+
+	checkEq(t, root.Parse(&State{}, []string{"sum", "1.5", "-2"}), nil)
+	checkEq(t, floats, []float64{1.5, -2})
+
+	err := root.Parse(&State{}, []string{"sum", "1.5", "2", "y"})
+	checkEq(t, err, ArgConversionError{Path: "sum", Index: 2, Value: "y", Type: "float64"})
+}
+
+func TestNamedArg(t *testing.T) {
+	var src, mode, other string
+	var srcOk, modeOk, otherOk bool
+	root := Action{
+		Trigger:    "cp",
+		MinConsume: 2,
+		MaxConsume: 3,
+		ArgNames:   []string{"src", "dst", "mode"},
+		Do: func(state *State, _ ...interface{}) error {
+			src, srcOk = state.NamedArg("src")
+			mode, modeOk = state.NamedArg("mode")
+			other, otherOk = state.NamedArg("other")
+			return nil
+		},
+	}
+	checkEq(t, root.Finalize(), nil)
+
+	checkEq(t, root.Parse(&State{}, []string{"cp", "a", "b"}), nil)
+	checkEq(t, src, "a")
+	checkEq(t, srcOk, true)
+	checkEq(t, mode, "")
+	checkEq(t, modeOk, false)
+	checkEq(t, other, "")
+	checkEq(t, otherOk, false)
+
+	checkEq(t, root.Parse(&State{}, []string{"cp", "a", "b", "fast"}), nil)
+	checkEq(t, mode, "fast")
+	checkEq(t, modeOk, true)
+}
+
+func TestConsumedCount(t *testing.T) {
+	var consumed, supplied int
+	record := func(state *State, _ ...interface{}) error {
+		consumed, supplied = state.ConsumedCount(), state.SuppliedCount()
+		return nil
+	}
+
+	root := Action{Trigger: "root"}
+	root.AddSubAction(Action{Trigger: "opt", MaxConsume: 3, Do: record, ArgEnv: map[int]string{2: "ARGO_TEST_COUNT"}})
+	root.AddSubAction(Action{Trigger: "all", MaxConsume: -1, Do: record})
+	checkEq(t, root.Finalize(), nil)
+
+	checkEq(t, root.Parse(&State{}, []string{"root", "opt", "a"}), nil)
+	checkEq(t, consumed, 1)
+	checkEq(t, supplied, 1)
+
+	checkEq(t, root.Parse(&State{}, []string{"root", "opt", "a", "b", "c", "d"}), nil)
+	checkEq(t, consumed, 3)
+	checkEq(t, supplied, 3)
+
+	checkEq(t, root.Parse(&State{}, []string{"root", "all", "a", "b", "c", "d"}), nil)
+	checkEq(t, consumed, 4)
+	checkEq(t, supplied, 4)
+
+	t.Setenv("ARGO_TEST_COUNT", "env")
+	checkEq(t, root.Parse(&State{}, []string{"root", "opt", "a", "b"}), nil)
+	checkEq(t, consumed, 2)
+	checkEq(t, supplied, 3)
+}
+
+func TestDisabledCommands(t *testing.T) {
+	triggered := ""
+	root := Action{Trigger: "root", AllowAbbrev: true}
+	root.AddSubAction(Action{
+		Trigger: "deploy",
+		Do: func(*State, ...interface{}) error {
+			triggered = "deploy"
+			return nil
+		},
+	})
+	root.Default = &Action{
+		Do: func(*State, ...interface{}) error {
+			triggered = "default"
+			return nil
+		},
+	}
+	checkEq(t, root.Finalize(), nil)
+
+	state := State{DisabledCommands: map[string]bool{"root deploy": true}}
+	checkEq(t, root.Parse(&state, []string{"root", "deploy"}), nil)
+	checkEq(t, triggered, "default")
+	checkEq(t, root.Parse(&state, []string{"root", "dep"}), nil)
+	checkEq(t, triggered, "default")
+
+	state.Reset()
+	checkEq(t, root.Parse(&state, []string{"root", "help"}), nil)
+	checkEq(t, strings.Contains(state.OutputStr.String(), "deploy"), false)
+
+	// Help of disabled commands is not shown either
+	state.Reset()
+	checkEq(t, root.Parse(&state, []string{"root", "help", "deploy"}), nil)
+	checkEq(t, state.OutputStr.String(), "Sub action not found: root deploy")
+
+	state = State{}
+	checkEq(t, root.Parse(&state, []string{"root", "help", "dep"}), nil)
+	checkEq(t, state.OutputStr.String(), root.GetSubActionPtr("deploy").Help())
+
+	state = State{}
+	checkEq(t, root.Parse(&state, []string{"root", "deploy"}), nil)
+	checkEq(t, triggered, "deploy")
+
+	checkEq(t, root.Parse(&state, []string{"root", "help"}), nil)
+	checkEq(t, strings.Contains(state.OutputStr.String(), "deploy"), true)
+}
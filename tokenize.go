@@ -0,0 +1,205 @@
+package argo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// UnterminatedQuoteError indicates a quoted string is not closed in the tokenized line
+type UnterminatedQuoteError struct {
+	Err
+	Line string
+}
+
+func (e UnterminatedQuoteError) Error() string {
+	return fmt.Sprintf("Parsing Error: Unterminated quote: %s", e.Line)
+}
+
+func (UnterminatedQuoteError) Code() string {
+	return "argo.unterminated_quote"
+}
+
+// tokenize splits line into statements of args, statements are separated by unquoted sep
+// sep == "" disables splitting statements. Empty statements are dropped
+func tokenize(line string, sep string) ([][]string, error) {
+	statements := [][]string{}
+	args := []string{}
+	arg := strings.Builder{}
+	inArg := false
+	var quote rune
+
+	endArg := func() {
+		if inArg {
+			args = append(args, arg.String())
+			arg.Reset()
+			inArg = false
+		}
+	}
+
+	endStatement := func() {
+		endArg()
+		if len(args) > 0 {
+			statements = append(statements, args)
+			args = []string{}
+		}
+	}
+
+	for index := 0; index < len(line); {
+		char, size := utf8.DecodeRuneInString(line[index:])
+		next, nextSize := utf8.DecodeRuneInString(line[index+size:])
+
+		switch {
+		case quote == '\'':
+			if char == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(char)
+			}
+		case quote == '"':
+			if char == '"' {
+				quote = 0
+			} else if char == '\\' && (next == '"' || next == '\\') {
+				arg.WriteRune(next)
+				size += nextSize
+			} else {
+				arg.WriteRune(char)
+			}
+		case char == '\'' || char == '"':
+			quote = char
+			inArg = true
+		case char == '\\' && index+size < len(line):
+			arg.WriteRune(next)
+			size += nextSize
+			inArg = true
+		case sep != "" && strings.HasPrefix(line[index:], sep):
+			endStatement()
+			size = len(sep)
+		case unicode.IsSpace(char):
+			endArg()
+		default:
+			arg.WriteRune(char)
+			inArg = true
+		}
+
+		index += size
+	}
+
+	if quote != 0 {
+		return nil, UnterminatedQuoteError{Line: line}
+	}
+	endStatement()
+
+	return statements, nil
+}
+
+// Tokenize splits line into args like a shell does
+// Args are separated by white spaces, which can be kept by single quotes, double quotes or backslash escapes
+func Tokenize(line string) ([]string, error) {
+	statements, err := tokenize(line, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(statements) == 0 {
+		return []string{}, nil
+	}
+	return statements[0], nil
+}
+
+// ParseString tokenizes line by Tokenize() and parses the args with current Action
+// If StatementSeparator is set, each statement in line is parsed in order with the same state,
+// and statements after the first one are args following the Trigger of this Action
+func (act Action) ParseString(state *State, line string, vargs ...interface{}) error {
+	statements, err := tokenize(line, act.StatementSeparator)
+	if err != nil {
+		return err
+	}
+
+	return act.parseStatements(state, statements, vargs)
+}
+
+// parseStatements parses each statement in order with the same state
+// Statements after the first one are parsed as args following the Trigger of act, as REPL() does
+// It returns the first error, and stops at the error unless ContinueOnError is set
+func (act Action) parseStatements(state *State, statements [][]string, vargs []interface{}) error {
+	var firstErr error
+	for index, args := range statements {
+		if index > 0 {
+			args = append([]string{act.Trigger}, args...)
+		}
+
+		if err := act.Parse(state, args, vargs...); err != nil {
+			if !act.ContinueOnError {
+				return err
+			}
+
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// ParseReader reads r line by line and parses each line as ParseString() does, without reading all of r at once
+// Each line ends a statement, and StatementSeparator splits statements within a line
+// A line with an unterminated quote is joined with the following lines until the quote is closed
+// Use ParseAll() instead to parse the whole of r as a single statement
+func (act Action) ParseReader(state *State, r io.Reader, vargs ...interface{}) error {
+	reader := bufio.NewReader(r)
+	pending := ""
+	var firstErr error
+	for {
+		line, readErr := reader.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+
+		line = pending + line
+		statements, err := tokenize(line, act.StatementSeparator)
+		if _, unterminated := err.(UnterminatedQuoteError); unterminated && readErr == nil {
+			pending = line
+			continue
+		}
+		pending = ""
+
+		if err != nil {
+			return err
+		}
+
+		if err := act.parseStatements(state, statements, vargs); err != nil {
+			if !act.ContinueOnError {
+				return err
+			}
+
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+
+		if readErr == io.EOF {
+			return firstErr
+		}
+	}
+}
+
+// ParseAll reads all of r, tokenizes it by Tokenize() and parses the args with current Action, like xargs does
+// Line breaks separate args as other white spaces do, so the whole input is a single statement
+// Read errors and UnterminatedQuoteError are returned as-is
+func (act Action) ParseAll(state *State, r io.Reader, vargs ...interface{}) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	args, err := Tokenize(string(data))
+	if err != nil {
+		return err
+	}
+	return act.Parse(state, args, vargs...)
+}
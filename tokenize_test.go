@@ -0,0 +1,169 @@
+package argo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestTokenize(t *testing.T) {
+	args, err := Tokenize(`cmd  'single quoted' "double \"quoted\"" back\ slash "" end`)
+	checkEq(t, err, nil)
+	checkEq(t, args, []string{"cmd", "single quoted", `double "quoted"`, "back slash", "", "end"})
+
+	args, err = Tokenize("  ")
+	checkEq(t, err, nil)
+	checkEq(t, args, []string{})
+
+	_, err = Tokenize(`cmd "open`)
+	checkTypeEq(t, err, UnterminatedQuoteError{})
+}
+
+func TestParseString(t *testing.T) {
+	act := Action{
+		Trigger:    "echo",
+		MaxConsume: -1,
+		Do: func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString(state.Args()[0])
+			return nil
+		},
+	}
+	checkEq(t, act.Finalize(), nil)
+
+	state := &State{}
+	checkEq(t, act.ParseString(state, `echo "hello world"`), nil)
+	checkEq(t, state.OutputStr.String(), "hello world")
+}
+
+func TestStatementSeparator(t *testing.T) {
+	act := Action{Trigger: "app", StatementSeparator: ";"}
+	act.AddSubAction(Action{
+		Trigger:    "set",
+		MinConsume: 2,
+		Do: func(state *State, _ ...interface{}) error {
+			state.OutputStr.WriteString(state.Args()[0] + "=" + state.Args()[1] + "\n")
+			return nil
+		},
+	})
+	checkEq(t, act.Finalize(), nil)
+
+	state := &State{}
+	checkEq(t, act.ParseString(state, `app set x 1; set y 2;; set z "3;4"`), nil)
+	checkEq(t, state.OutputStr.String(), "x=1\ny=2\nz=3;4\n")
+
+	state = &State{}
+	err := act.ParseString(state, `app set x; set y 2`)
+	checkTypeEq(t, err, TooFewArgsError{})
+	checkEq(t, state.OutputStr.String(), "")
+
+	act.ContinueOnError = true
+	state = &State{}
+	err = act.ParseString(state, `app set x; set y 2`)
+	checkTypeEq(t, err, TooFewArgsError{})
+	checkEq(t, state.OutputStr.String(), "y=2\n")
+}
+
+func TestParseReader(t *testing.T) {
+	sum := 0
+	act := Action{
+		Trigger:            "add",
+		MinConsume:         1,
+		MaxConsume:         1,
+		StatementSeparator: ";",
+		Do: func(state *State, _ ...interface{}) error {
+			values, err := state.ArgsInt()
+			if err != nil {
+				return err
+			}
+			sum += values[0]
+			return nil
+		},
+	}
+	checkEq(t, act.Finalize(), nil)
+
+	// Generate a large input without building it in memory
+	reader, writer := io.Pipe()
+	go func() {
+		for i := 1; i <= 10000; i++ {
+			fmt.Fprintf(writer, "add %d; %d\n", i, -i+1)
+		}
+		writer.Close()
+	}()
+	checkEq(t, act.ParseReader(&State{}, iotest.OneByteReader(reader)), nil)
+	checkEq(t, sum, 10000)
+
+	// The last line does not need a line break
+	sum = 0
+	checkEq(t, act.ParseReader(&State{}, strings.NewReader("add 1\nadd 2")), nil)
+	checkEq(t, sum, 3)
+
+	sum = 0
+	err := act.ParseReader(&State{}, strings.NewReader("add 1\nadd x\nadd 2"))
+	checkTypeEq(t, err, ArgConversionError{})
+	checkEq(t, sum, 1)
+
+	checkTypeEq(t, act.ParseReader(&State{}, strings.NewReader("add 'open\n")), UnterminatedQuoteError{})
+}
+
+func TestParseReaderMultilineQuote(t *testing.T) {
+	output := []string{}
+	act := Action{
+		Trigger:    "echo",
+		MaxConsume: -1,
+		Do: func(state *State, _ ...interface{}) error {
+			output = append(output, strings.Join(state.Args(), "|"))
+			return nil
+		},
+	}
+	checkEq(t, act.Finalize(), nil)
+
+	input := "echo 'first\nsecond' x\necho y\n"
+	checkEq(t, act.ParseReader(&State{}, iotest.OneByteReader(strings.NewReader(input))), nil)
+	checkEq(t, output, []string{"first\nsecond|x", "y"})
+}
+
+func TestParseReaderQuotedArgs(t *testing.T) {
+	var args []string
+	act := Action{
+		Trigger:    "grep",
+		MaxConsume: -1,
+		Do: func(state *State, _ ...interface{}) error {
+			args = state.Args()
+			return nil
+		},
+	}
+	checkEq(t, act.Finalize(), nil)
+
+	checkEq(t, act.ParseReader(&State{}, strings.NewReader(`grep "hello world" 'a b' c\ d`)), nil)
+	checkEq(t, args, []string{"hello world", "a b", "c d"})
+
+	readErr := errors.New("read failed")
+	checkEq(t, act.ParseReader(&State{}, iotest.ErrReader(readErr)), readErr)
+}
+
+func TestParseAll(t *testing.T) {
+	var args []string
+	act := Action{
+		Trigger:    "grep",
+		MaxConsume: -1,
+		Do: func(state *State, _ ...interface{}) error {
+			args = state.Args()
+			return nil
+		},
+	}
+	checkEq(t, act.Finalize(), nil)
+
+	checkEq(t, act.ParseAll(&State{}, strings.NewReader("grep\nfoo\nbar\n")), nil)
+	checkEq(t, args, []string{"foo", "bar"})
+
+	checkEq(t, act.ParseAll(&State{}, iotest.OneByteReader(strings.NewReader("grep 'hello\nworld' x"))), nil)
+	checkEq(t, args, []string{"hello\nworld", "x"})
+
+	checkTypeEq(t, act.ParseAll(&State{}, strings.NewReader("grep 'open")), UnterminatedQuoteError{})
+
+	readErr := errors.New("read failed")
+	checkEq(t, act.ParseAll(&State{}, iotest.ErrReader(readErr)), readErr)
+}
@@ -0,0 +1,130 @@
+package argo
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ArgConversionError indicates a consumed arg cannot be converted into the requested type
+type ArgConversionError struct {
+	Err
+	Path  string
+	Index int
+	Value string
+	Type  string
+}
+
+func (e ArgConversionError) Error() string {
+	return fmt.Sprintf("Parsing Error: Cannot convert arg %d %q to %s\nActionPath: %s",
+		e.Index+1, e.Value, e.Type, e.Path)
+}
+
+func (ArgConversionError) Code() string {
+	return "argo.arg_conversion"
+}
+
+// bindFields returns indexes of fields in struct type t which can be bound from args
+func bindFields(t reflect.Type) []int {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fields := []int{}
+	for index := 0; index < t.NumField(); index++ {
+		field := t.Field(index)
+		if field.PkgPath != "" || field.Tag.Get("argo") == "-" {
+			// unexported or ignored
+			continue
+		}
+
+		switch field.Type.Kind() {
+		case reflect.String, reflect.Bool,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			fields = append(fields, index)
+		}
+	}
+	return fields
+}
+
+// setField converts value and stores it into field, integers are parsed as decimal
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(v)
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(v)
+	}
+	return nil
+}
+
+// TypedAction creates an Action which binds consumed args into a T before calling handler
+// T should be a struct, its exported fields of string, bool, integer and float types are bound in declaring order
+// MinConsume and MaxConsume are set to the number of bound fields, MinConsume can be lowered to make trailing fields optional
+// ArgNames are taken from `argo:"name"` field tags, or lower-cased field names. Fields tagged `argo:"-"` are skipped
+func TypedAction[T any](trigger string, handler func(*State, T) error) Action {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	fields := bindFields(typ)
+
+	argNames := []string{}
+	for _, index := range fields {
+		field := typ.Field(index)
+		name := field.Tag.Get("argo")
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		argNames = append(argNames, name)
+	}
+
+	return Action{
+		Trigger:    trigger,
+		MinConsume: len(fields),
+		MaxConsume: len(fields),
+		ArgNames:   argNames,
+		Do: func(state *State, _ ...interface{}) error {
+			var value T
+			target := reflect.ValueOf(&value).Elem()
+			for argIndex, arg := range state.Args() {
+				if argIndex >= len(fields) {
+					break
+				}
+
+				field := target.Field(fields[argIndex])
+				if err := setField(field, arg); err != nil {
+					return ArgConversionError{
+						Path:  state.path,
+						Index: argIndex,
+						Value: redactArg(state.sensitiveArgs, argIndex, arg),
+						Type:  field.Type().String(),
+					}
+				}
+			}
+			return handler(state, value)
+		},
+	}
+}
@@ -0,0 +1,50 @@
+package argo
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type copyArgs struct {
+	Source string `argo:"src"`
+	Dest   string
+	Times  int
+	Force  bool
+	Ignore string `argo:"-"`
+	unused int
+}
+
+func TestTypedAction(t *testing.T) {
+	act := TypedAction("copy", func(state *State, args copyArgs) error {
+		fmt.Fprintf(&state.OutputStr, "%s %s %d %t", args.Source, args.Dest, args.Times, args.Force)
+		return nil
+	})
+	checkEq(t, act.ArgNames, []string{"src", "dest", "times", "force"})
+	checkEq(t, act.MinConsume, 4)
+	checkEq(t, act.MaxConsume, 4)
+
+	act.MinConsume = 2
+	checkEq(t, act.Finalize(), nil)
+
+	state := &State{}
+	checkEq(t, act.Parse(state, []string{"copy", "a", "b", "3", "true"}), nil)
+	checkEq(t, state.OutputStr.String(), "a b 3 true")
+
+	state = &State{}
+	checkEq(t, act.Parse(state, []string{"copy", "a", "b"}), nil)
+	checkEq(t, state.OutputStr.String(), "a b 0 false")
+
+	// Integers are decimal
+	state = &State{}
+	checkEq(t, act.Parse(state, []string{"copy", "a", "b", "010"}), nil)
+	checkEq(t, state.OutputStr.String(), "a b 10 false")
+
+	err := act.Parse(&State{}, []string{"copy", "a", "b", "x"})
+	argoErr, ok := err.(ArgConversionError)
+	checkEq(t, ok, true)
+	checkEq(t, argoErr.Index, 2)
+	checkEq(t, argoErr.Value, "x")
+	checkEq(t, argoErr.Type, "int")
+	checkEq(t, strings.Contains(argoErr.Error(), "copy"), true)
+}